@@ -11,6 +11,7 @@ import (
 	db "github.com/ExonegeS/REST-API-001/internal/adapter/postgres"
 	"github.com/ExonegeS/REST-API-001/internal/api/http/handler"
 	"github.com/ExonegeS/REST-API-001/internal/config"
+	"github.com/ExonegeS/REST-API-001/internal/domain"
 	"github.com/ExonegeS/REST-API-001/internal/repository"
 	"github.com/ExonegeS/REST-API-001/internal/service"
 	"github.com/ExonegeS/REST-API-001/internal/usecase"
@@ -25,30 +26,93 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
-	// TODO:
-	// init DB connection
-	dbConn, err := db.ConnectToPostgresDB(cfg.Database.HOST, cfg.Database.PORT, cfg.Database.USER, cfg.Database.PASS, cfg.Database.NAME)
-	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
-	}
-	defer db.DisconnectFromPostgresDB(dbConn)
+	var usersRepository repository.UsersRepository
+	var auditRepository repository.AuditRepository
+	var txManager repository.TxManager
+	var idempotencyStore domain.IdempotencyStore
+	switch cfg.Storage.Backend {
+	case "memory":
+		store := repository.NewMemoryStore()
+		if cfg.Storage.Fixture != "" {
+			if err := store.LoadUsersFixture(cfg.Storage.Fixture); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+		usersRepository = repository.NewUsersMemoryRepository(store)
+		auditRepository = repository.NewAuditMemoryRepository(store)
+		txManager = repository.NewMemoryTxManager(store)
+		idempotencyStore = repository.NewIdempotencyMemoryRepository()
+	default:
+		dbConn, err := db.ConnectToPostgresDB(cfg.Database.HOST, cfg.Database.PORT, cfg.Database.USER, cfg.Database.PASS, cfg.Database.NAME)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		defer db.DisconnectFromPostgresDB(dbConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	_ = ctx
-	defer cancel()
+		if cfg.Database.AutoMigrate {
+			migrator, err := db.NewMigrator(dbConn)
+			if err == nil {
+				err = migrator.Up(context.Background())
+			}
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error occured while migrating database: %s", err))
+				os.Exit(1)
+			}
+		}
 
-	usersRepository := repository.NewUsersRepository(dbConn)
-	usersUseCase := usecase.NewUsersUseCase(usersRepository)
+		usersRepository = repository.NewUsersRepository(dbConn)
+		auditRepository = repository.NewAuditRepository(dbConn)
+		txManager = repository.NewTxManager(dbConn)
+		idempotencyStore = repository.NewIdempotencyRepository(dbConn)
+	}
+
+	usersUseCase := usecase.NewUsersUseCase(usersRepository, auditRepository, txManager, usecase.UsersPolicy{
+		EmailReuseAfter: cfg.Users.EmailReuseAfter,
+	})
 
 	svc := service.NewUsersService(usersUseCase)
 	svc = service.NewLoggingService(logger, svc)
 
-	srv := handler.NewApiServer(svc)
+	srv := handler.NewApiServer(svc, handler.Options{
+		AdminToken:       cfg.Auth.AdminToken,
+		ActorProxySecret: cfg.Auth.ActorProxySecret,
+		RequireIfMatch:   cfg.Server.RequireIfMatch,
+		MaxBatchSize:     cfg.Users.BatchMaxItems,
+		MaxImportRows:    cfg.Users.ImportMaxRows,
+		MaxBatchGetKeys:  cfg.Users.BatchGetMaxKeys,
+
+		IdempotencyStore: idempotencyStore,
+		IdempotencyTTL:   cfg.Users.IdempotencyKeyTTL,
+	})
+	go purgeIdempotencyKeys(idempotencyStore, time.Hour)
 	log.Fatal(srv.Start(cfg.Server.Port))
 	// init server
 	// Start server listening
 	// Create gracefull shutdown
 }
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval.
+func purgeIdempotencyKeys(store domain.IdempotencyStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		deleted, err := store.DeleteExpired(context.Background())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error occured while deleting expired idempotency keys: %s", err))
+			continue
+		}
+		if deleted > 0 {
+			slog.Info(fmt.Sprintf("Deleted %d expired idempotency keys", deleted))
+		}
+	}
+}
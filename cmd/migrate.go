@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	db "github.com/ExonegeS/REST-API-001/internal/adapter/postgres"
+	"github.com/ExonegeS/REST-API-001/internal/config"
+)
+
+const migrateUsage = "usage: app migrate up|down|status|goto N"
+
+// runMigrate implements the "migrate" subcommand.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	dbConn, err := db.ConnectToPostgresDB(cfg.Database.HOST, cfg.Database.PORT, cfg.Database.USER, cfg.Database.PASS, cfg.Database.NAME)
+	if err != nil {
+		return err
+	}
+	defer db.DisconnectFromPostgresDB(dbConn)
+
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "goto":
+		if len(args) != 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+		return migrator.Goto(ctx, version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%06d %-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
@@ -4,10 +4,11 @@ go 1.23.0
 
 toolchain go1.23.7
 
-require github.com/lib/pq v1.10.9
-
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
 )
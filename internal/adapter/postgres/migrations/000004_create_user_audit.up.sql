@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+    id bigserial PRIMARY KEY,
+    user_id uuid NOT NULL,
+    actor character varying(255) NOT NULL,
+    operation character varying(16) NOT NULL,
+    changes jsonb NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, id DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_search_trgm_idx;
+DROP INDEX IF EXISTS users_search_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS search;
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', first_name || ' ' || last_name || ' ' || email)) STORED;
+
+CREATE INDEX IF NOT EXISTS users_search_idx ON users USING gin (search);
+
+CREATE INDEX IF NOT EXISTS users_search_trgm_idx ON users
+    USING gin ((first_name || ' ' || last_name || ' ' || email) gin_trgm_ops);
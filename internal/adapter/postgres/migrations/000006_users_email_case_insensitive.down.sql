@@ -0,0 +1,4 @@
+-- Resolved collisions are not undone: the placeholder emails stay.
+DROP INDEX IF EXISTS users_email_lower_key;
+ALTER TABLE users ADD CONSTRAINT unique_email UNIQUE (email);
+DROP TABLE IF EXISTS user_email_collisions;
@@ -0,0 +1,59 @@
+-- Emails become unique regardless of case. Users whose emails already
+-- collide that way are resolved first: the oldest active user keeps the
+-- address, and every other one is soft-deleted and moved to a placeholder
+-- address, which passes the email validation so that a restored user can
+-- be updated without replacing it first. user_email_collisions reports each
+-- resolution with the original email, so that support can merge the
+-- accounts by hand.
+CREATE TABLE IF NOT EXISTS user_email_collisions (
+    user_id uuid PRIMARY KEY,
+    email character varying(255) NOT NULL,
+    kept_user_id uuid NOT NULL,
+    resolved_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+WITH ranked AS (
+    SELECT id, email,
+           first_value(id) OVER w AS kept_user_id,
+           row_number() OVER w AS rank
+    FROM users
+    WINDOW w AS (PARTITION BY lower(email) ORDER BY deleted_at IS NOT NULL, created_at, id)
+)
+INSERT INTO user_email_collisions (user_id, email, kept_user_id)
+SELECT id, email, kept_user_id FROM ranked WHERE rank > 1;
+
+UPDATE users u
+SET email = 'collision+' || u.id || '@invalid.example',
+    deleted_at = COALESCE(u.deleted_at, c.resolved_at),
+    updated_at = c.resolved_at,
+    version = u.version + 1
+FROM user_email_collisions c
+WHERE c.user_id = u.id;
+
+INSERT INTO user_audit (user_id, actor, operation, changes, created_at)
+SELECT c.user_id, 'migration', 'delete',
+       jsonb_build_array(jsonb_build_object('field', 'email', 'before', c.email, 'after', u.email)),
+       c.resolved_at
+FROM user_email_collisions c
+JOIN users u ON u.id = c.user_id;
+
+-- Domains are stored lower-cased. Punycode needs no migration: the email
+-- format never allowed non-ASCII domains.
+WITH lowered AS (
+    UPDATE users u
+    SET email = substring(old.email FROM '^(.*)@[^@]*$') || lower(substring(old.email FROM '@[^@]*$')),
+        updated_at = now(),
+        version = u.version + 1
+    FROM (SELECT id, email FROM users) old
+    WHERE old.id = u.id
+      AND substring(old.email FROM '@[^@]*$') <> lower(substring(old.email FROM '@[^@]*$'))
+    RETURNING u.id, old.email AS before, u.email AS after, u.updated_at
+)
+INSERT INTO user_audit (user_id, actor, operation, changes, created_at)
+SELECT id, 'migration', 'update',
+       jsonb_build_array(jsonb_build_object('field', 'email', 'before', before, 'after', after)),
+       updated_at
+FROM lowered;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS unique_email;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
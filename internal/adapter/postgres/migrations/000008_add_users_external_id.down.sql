@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_external_id_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_external_id_check;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+ALTER TABLE users DROP COLUMN IF EXISTS external_source;
@@ -0,0 +1,9 @@
+-- The identifier of a user in another system, such as SSO or billing. It is
+-- unique per source, and both columns are set or neither is.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_source character varying(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id character varying(255);
+
+ALTER TABLE users ADD CONSTRAINT users_external_id_check
+    CHECK ((external_source IS NULL) = (external_id IS NULL));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_external_id_key ON users (external_source, external_id);
@@ -8,8 +8,11 @@ import (
 )
 
 func ConnectToPostgresDB(host string, port int, user, password, dbname string) (*sql.DB, error) {
+	// The word similarity threshold is lowered from 0.6 so that the fuzzy
+	// user search (the <% operator) matches typos in short names.
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=disable",
+		"password=%s dbname=%s sslmode=disable "+
+		"pg_trgm.word_similarity_threshold=0.3",
 		host, port, user, password, dbname)
 	db, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
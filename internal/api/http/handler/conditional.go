@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeConditional writes v as JSON with the validators etag and, unless it
+// is zero, lastModified. Clients that already hold the current
+// representation, as told by If-None-Match or If-Modified-Since, get a 304
+// without a body instead.
+func writeConditional(w http.ResponseWriter, r *http.Request, v any, etag string, lastModified time.Time) error {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return writeJSON(w, http.StatusOK, v)
+}
+
+// writeHashed writes v as JSON with an entity tag hashed from its encoding,
+// so that unchanged results can be answered with a 304.
+func writeHashed(w http.ResponseWriter, r *http.Request, v any) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// notModified evaluates the conditional headers of a GET. If-None-Match takes
+// precedence, and If-Modified-Since is only used without it.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagListMatches(header, etag)
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a precision of one second.
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// etagListMatches reports whether an If-None-Match header lists etag, using
+// the weak comparison it calls for.
+func etagListMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
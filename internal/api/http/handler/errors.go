@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+// Error codes of failures detected by the handlers themselves.
+const (
+	codePreconditionRequired = "precondition_required"
+	codeForbidden            = "forbidden"
+	codeRouteNotFound        = "route_not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeIdempotencyMismatch  = "idempotency_key_mismatch"
+	codeIdempotencyInFlight  = "idempotency_key_in_use"
+)
+
+// problem is an RFC 7807 problem details object, extended with a stable
+// error code and the failures of invalid fields.
+type problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail,omitempty"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Errors   []fieldProblem `json:"errors,omitempty"`
+}
+
+type fieldProblem struct {
+	Pointer string `json:"pointer"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type requestIDKey struct{}
+
+// requestIDMiddleware tags every request with an ID, taken from the
+// X-Request-ID header of the gateway when it sends a sane one, and echoes it
+// in the response. Problem responses use it as their instance.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// errorStatus maps an error to the HTTP status of its kind.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, domain.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes err as a problem with the status and code of its kind.
+// Unexpected errors are logged, and their details are kept from the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	p := problem{
+		Status: status,
+		Detail: err.Error(),
+		Code:   domain.ErrorCode(err),
+	}
+
+	switch status {
+	case http.StatusInternalServerError:
+		slog.Error("request failed", "err", err, "request_id", requestID(r))
+		p.Detail = "internal server error"
+	case http.StatusServiceUnavailable:
+		slog.Error("request failed", "err", err, "request_id", requestID(r))
+		p.Detail = "service temporarily unavailable"
+	}
+
+	var fieldErrs domain.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		for _, fieldErr := range fieldErrs {
+			// An error without a field concerns the input as a whole.
+			pointer := ""
+			if fieldErr.Field != "" {
+				pointer = "/" + fieldErr.Field
+			}
+			p.Errors = append(p.Errors, fieldProblem{
+				Pointer: pointer,
+				Code:    fieldErr.Code,
+				Message: fieldErr.Message,
+			})
+		}
+	}
+
+	writeProblem(w, r, p)
+}
+
+// writeErrorStatus writes a problem found by the handler itself.
+func writeErrorStatus(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, problem{
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Instance = requestID(r)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeErrorStatus(w, r, http.StatusNotFound, codeRouteNotFound, "no route matches "+r.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeErrorStatus(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
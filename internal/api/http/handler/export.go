@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+// exportFlushEvery is the number of rows written between flushes, so that
+// clients see the export progress instead of one response at the end.
+const exportFlushEvery = 500
+
+// exportWriteTimeout is how long a client may take to read the rows written
+// between two flushes. Exports hold a database cursor open, so a client that
+// stops reading is cut off rather than kept waiting on.
+const exportWriteTimeout = 30 * time.Second
+
+// csvFormulaPrefixes are the first characters that make spreadsheets read a
+// cell as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// userExporter writes users in an export format. It only sends the response
+// headers with the first write, so that errors found before any row is
+// exported can still be reported with a proper status.
+type userExporter struct {
+	w       http.ResponseWriter
+	params  exportParams
+	csv     *csv.Writer
+	started bool
+	rows    int
+}
+
+func newUserExporter(w http.ResponseWriter, params exportParams) *userExporter {
+	return &userExporter{
+		w:      w,
+		params: params,
+	}
+}
+
+func (e *userExporter) start() error {
+	e.started = true
+
+	contentType, ext := "text/csv; charset=utf-8", "csv"
+	if e.params.format == exportNDJSON {
+		contentType, ext = "application/x-ndjson", "ndjson"
+	}
+	e.w.Header().Set("Content-Type", contentType)
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, ext))
+	if err := e.extendWriteDeadline(); err != nil {
+		return err
+	}
+	e.w.WriteHeader(http.StatusOK)
+
+	if e.params.format == exportCSV {
+		e.csv = csv.NewWriter(e.w)
+		headers := make([]string, len(e.params.headers))
+		for i, header := range e.params.headers {
+			headers[i] = csvCell(header)
+		}
+		return e.csv.Write(headers)
+	}
+	return nil
+}
+
+// extendWriteDeadline gives the client exportWriteTimeout to read what is
+// written next.
+func (e *userExporter) extendWriteDeadline() error {
+	err := http.NewResponseController(e.w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (e *userExporter) write(user domain.User) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.params.format == exportCSV {
+		err = e.writeCSV(user)
+	} else {
+		err = e.writeNDJSON(user)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *userExporter) writeCSV(user domain.User) error {
+	record := make([]string, len(e.params.columns))
+	for i, column := range e.params.columns {
+		record[i] = csvCell(csvValue(user.Field(column)))
+	}
+	return e.csv.Write(record)
+}
+
+// writeNDJSON writes user as one JSON object keyed by the header names, in
+// column order.
+func (e *userExporter) writeNDJSON(user domain.User) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range e.params.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(e.params.headers[i])
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(user.Field(column))
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// finish completes the export, writing the headers of an empty export.
+func (e *userExporter) finish() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+func (e *userExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	err := http.NewResponseController(e.w).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return e.extendWriteDeadline()
+}
+
+// csvCell keeps spreadsheets from running s as a formula by prefixing it with
+// a quote when it starts like one.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune(csvFormulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case uuid.UUID:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return fmt.Sprint(v)
+}
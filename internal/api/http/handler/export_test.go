@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "John", want: "John"},
+		{value: "=HYPERLINK(\"http://evil.example\")", want: "'=HYPERLINK(\"http://evil.example\")"},
+		{value: "+1-555-0100", want: "'+1-555-0100"},
+		{value: "-2+3", want: "'-2+3"},
+		{value: "@SUM(A1:A2)", want: "'@SUM(A1:A2)"},
+		{value: "\tcmd", want: "'\tcmd"},
+		{value: "\rcmd", want: "'\rcmd"},
+		{value: "O'Brien", want: "O'Brien"},
+		{value: "", want: ""},
+	}
+
+	params := exportParams{
+		format:  exportCSV,
+		columns: []string{"first_name", "email"},
+		headers: []string{"=name", "email"},
+	}
+	w := httptest.NewRecorder()
+	exporter := newUserExporter(w, params)
+	for _, tt := range tests {
+		if err := exporter.write(domain.User{FirstName: tt.value, Email: "a@example.com"}); err != nil {
+			t.Fatalf("write(%q) error = %v", tt.value, err)
+		}
+	}
+	if err := exporter.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the export: %v", err)
+	}
+	if want := []string{"'=name", "email"}; !slices.Equal(records[0], want) {
+		t.Errorf("header = %q, want %q", records[0], want)
+	}
+	if len(records) != len(tests)+1 {
+		t.Fatalf("got %d records, want %d", len(records), len(tests)+1)
+	}
+	for i, tt := range tests {
+		if got := records[i+1][0]; got != tt.want {
+			t.Errorf("cell of %q = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
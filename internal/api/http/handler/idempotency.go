@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+// maxIdempotencyKeyLength caps the length of Idempotency-Key headers.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLease is how long a claim on a key lasts without being renewed.
+// Requests renew it while they run, so that only a claim left behind by a
+// crashed server runs out and can be taken over, instead of blocking retries
+// for the whole TTL.
+const idempotencyLease = time.Minute
+
+// replayedHeaders are the response headers stored with an idempotent
+// response and sent again when it is replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotent makes next safe to retry with an Idempotency-Key header. The
+// first response for a key is stored and replayed to retries of the same
+// request. Reusing the key for a different request is rejected with 422, and
+// a retry arriving while the first request is still handled with 409.
+// Requests without the header, or without a store configured, go through.
+func (s *ApiServer) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || s.opts.IdempotencyStore == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Error reading request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key = scopedIdempotencyKey(domain.ActorFromContext(r.Context()), key)
+		fingerprint := requestFingerprint(r, body)
+
+		store := s.opts.IdempotencyStore
+		stored, err := store.Begin(r.Context(), key, fingerprint, time.Now().Add(idempotencyLease))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.Fingerprint != fingerprint:
+				writeErrorStatus(w, r, http.StatusUnprocessableEntity, codeIdempotencyMismatch, "Idempotency-Key was already used with a different request")
+			case !stored.Completed:
+				writeErrorStatus(w, r, http.StatusConflict, codeIdempotencyInFlight, "a request with this Idempotency-Key is still in progress")
+			default:
+				replayResponse(w, stored)
+			}
+			return
+		}
+
+		// The outcome is stored even if the client went away, since that is
+		// when it is most likely to retry.
+		ctx := context.WithoutCancel(r.Context())
+		defer func() {
+			if p := recover(); p != nil {
+				store.Release(ctx, key)
+				panic(p)
+			}
+		}()
+
+		stopRenewing := renewLease(ctx, store, key, fingerprint)
+		defer stopRenewing()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+		stopRenewing()
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		if recorder.status >= http.StatusInternalServerError {
+			// Failures of the server may not happen again, so the request
+			// can be retried with the same key.
+			err = store.Release(ctx, key)
+		} else {
+			err = store.Complete(ctx, domain.IdempotentResponse{
+				Key:         key,
+				Fingerprint: fingerprint,
+				Status:      recorder.status,
+				Header:      recorder.storedHeader(),
+				Body:        recorder.body.Bytes(),
+				ExpiresAt:   time.Now().Add(s.opts.IdempotencyTTL),
+			})
+		}
+		if err != nil {
+			slog.Error("error storing idempotent response", "err", err, "request_id", requestID(r))
+		}
+	}
+}
+
+// renewLease extends the claim on key every third of idempotencyLease, until
+// the returned function is called. Calling it again does nothing.
+func renewLease(ctx context.Context, store domain.IdempotencyStore, key, fingerprint string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(idempotencyLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := store.Extend(ctx, key, fingerprint, time.Now().Add(idempotencyLease)); err != nil {
+					slog.Error("error extending idempotency key", "err", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// scopedIdempotencyKey scopes key to the actor, so that clients can't replay
+// each other's responses. The pair is hashed to a fixed-length hex string
+// that any actor and key fit in.
+func scopedIdempotencyKey(actor, key string) string {
+	hash := sha256.New()
+	io.WriteString(hash, actor)
+	hash.Write([]byte{0})
+	io.WriteString(hash, key)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replayResponse(w http.ResponseWriter, stored *domain.IdempotentResponse) {
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) storedHeader() http.Header {
+	header := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := rec.Header().Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+	return header
+}
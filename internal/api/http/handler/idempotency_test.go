@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/repository"
+)
+
+func idempotencyTestServer() *ApiServer {
+	return NewApiServer(nil, Options{
+		IdempotencyStore: repository.NewIdempotencyMemoryRepository(),
+		IdempotencyTTL:   time.Hour,
+	})
+}
+
+func idempotentRequest(h http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotencyTestServer().idempotent(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Location", "/users/1")
+		w.Header().Set("X-Other", "dropped")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+
+	first := idempotentRequest(h, "k1", `{"email":"a@example.com"}`)
+	retry := idempotentRequest(h, "k1", `{"email":"a@example.com"}`)
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want once", calls.Load())
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Location") != "/users/1" {
+		t.Errorf("retry headers = %v, want the replayed Location", retry.Header())
+	}
+	if retry.Header().Get("X-Other") != "" {
+		t.Errorf("retry replayed X-Other, which is not stored")
+	}
+
+	// Another key is another request.
+	if other := idempotentRequest(h, "k2", `{"email":"a@example.com"}`); other.Header().Get("Idempotent-Replayed") != "" || calls.Load() != 2 {
+		t.Errorf("request with another key was replayed")
+	}
+}
+
+func TestIdempotentBodyMismatch(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotencyTestServer().idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	idempotentRequest(h, "k1", `{"email":"a@example.com"}`)
+	w := idempotentRequest(h, "k1", `{"email":"b@example.com"}`)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+}
+
+func TestIdempotentInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := idempotencyTestServer().idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentRequest(h, "k1", `{}`) }()
+	<-started
+
+	if w := idempotentRequest(h, "k1", `{}`); w.Code != http.StatusConflict {
+		t.Errorf("concurrent retry status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := idempotentRequest(h, "k1", `{}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("later retry = %d, replayed %q, want a replayed %d", w.Code, w.Header().Get("Idempotent-Replayed"), http.StatusCreated)
+	}
+}
+
+func TestIdempotentServerErrorReleasesKey(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotencyTestServer().idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	idempotentRequest(h, "k1", `{}`)
+	if w := idempotentRequest(h, "k1", `{}`); w.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Errorf("retry after a server error = %d after %d calls, want %d after 2", w.Code, calls.Load(), http.StatusCreated)
+	}
+}
+
+func TestIdempotentLeaseExtend(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewIdempotencyMemoryRepository()
+
+	if _, err := store.Begin(ctx, "k1", "f1", time.Now().Add(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Extend(ctx, "k1", "f1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	// The extended claim is still held, so a retry can't take it over.
+	stored, err := store.Begin(ctx, "k1", "f1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Completed {
+		t.Fatalf("Begin() = %+v, want the claim in progress", stored)
+	}
+
+	// A lapsed claim is taken over.
+	if _, err := store.Begin(ctx, "k2", "f1", time.Now().Add(-time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Begin(ctx, "k2", "f1", time.Now().Add(time.Minute)); err != nil || stored != nil {
+		t.Fatalf("Begin() of a lapsed claim = %+v, %v, want it taken over", stored, err)
+	}
+}
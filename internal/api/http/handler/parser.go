@@ -1,12 +1,30 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
 )
 
-func parseQueryParams(r *http.Request) (limit int, offset int, orderBy *string, query *string, err error) {
+// paramError reports an invalid query parameter.
+func paramError(name, format string, args ...any) error {
+	var errs domain.ValidationErrors
+	errs.Add(name, domain.FieldInvalid, format, args...)
+	return errs
+}
+
+func parsePagination(r *http.Request) (limit int, offset int, err error) {
 	queryParams := r.URL.Query()
 
 	limitStr := queryParams.Get("limit")
@@ -15,29 +33,478 @@ func parseQueryParams(r *http.Request) (limit int, offset int, orderBy *string,
 	} else {
 		limit, err = strconv.Atoi(limitStr)
 		if err != nil {
-			return 0, 0, nil, nil, fmt.Errorf("invalid limit value: %v", err)
+			return 0, 0, paramError("limit", "invalid limit value: %v", err)
 		}
 	}
 
 	offsetStr := queryParams.Get("offset")
-	if offsetStr == "" {
-		offset = 0
-	} else {
+	if offsetStr != "" {
 		offset, err = strconv.Atoi(offsetStr)
 		if err != nil {
-			return 0, 0, nil, nil, fmt.Errorf("invalid offset value: %v", err)
+			return 0, 0, paramError("offset", "invalid offset value: %v", err)
 		}
 	}
 
-	orderByStr := queryParams.Get("order_by")
-	if orderByStr != "" {
-		orderBy = &orderByStr
+	return limit, offset, nil
+}
+
+func parseQueryParams(r *http.Request) (input domain.GetUsersInput, err error) {
+	queryParams := r.URL.Query()
+
+	input.Limit, input.Offset, err = parsePagination(r)
+	if err != nil {
+		return domain.GetUsersInput{}, err
+	}
+
+	input.Sort, err = parseSortParams(r)
+	if err != nil {
+		return domain.GetUsersInput{}, err
 	}
 
 	queryStr := queryParams.Get("query")
 	if queryStr != "" {
-		query = &queryStr
+		input.Query = &queryStr
+	}
+
+	input.IncludeDeleted, err = parseBoolParam(r, "include_deleted")
+	if err != nil {
+		return domain.GetUsersInput{}, err
+	}
+
+	cursorStr := queryParams.Get("cursor")
+	if cursorStr != "" {
+		input.Cursor = &cursorStr
+	}
+
+	input.Filters, err = parseFilters(r)
+	if err != nil {
+		return domain.GetUsersInput{}, err
+	}
+
+	input.Fields = parseFieldsParam(r)
+
+	return input, nil
+}
+
+// parseFilters reads the filters of the users list from parameters of the
+// form field[op]=value, or field=value for eq. Parameters without an operator
+// that don't name a filter field are left alone, like other unknown
+// parameters.
+func parseFilters(r *http.Request) ([]domain.Filter, error) {
+	params := r.URL.Query()
+
+	var filters []domain.Filter
+	var errs domain.ValidationErrors
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		field, op, hasOp := strings.Cut(name, "[")
+		if hasOp {
+			var ok bool
+			if op, ok = strings.CutSuffix(op, "]"); !ok {
+				errs.Add(name, domain.FieldInvalid, "malformed filter parameter %s, must be of the form field[op]", name)
+				continue
+			}
+		} else if _, ok := domain.UserFilterOps[field]; !ok {
+			continue
+		}
+
+		for _, value := range params[name] {
+			filter, err := domain.ParseFilter(field, op, value)
+			if err != nil {
+				errs.Add(name, domain.FieldInvalid, "%s: %v", name, err)
+				break
+			}
+			filters = append(filters, filter)
+		}
+	}
+	return filters, errs.Err()
+}
+
+// parseBatchGetParams reads the keys of a batch get from the comma-separated
+// ids and emails parameters of a GET /users.
+func parseBatchGetParams(r *http.Request, maxKeys int) (input domain.BatchGetUsersInput, err error) {
+	queryParams := r.URL.Query()
+	input.IDs = splitListParam(queryParams.Get("ids"))
+	input.Emails = splitListParam(queryParams.Get("emails"))
+	input.Fields = parseFieldsParam(r)
+
+	input.IncludeDeleted, err = parseBoolParam(r, "include_deleted")
+	if err != nil {
+		return domain.BatchGetUsersInput{}, err
+	}
+
+	return input, checkBatchGetSize(input, maxKeys)
+}
+
+// checkBatchGetSize caps the number of keys of a batch get.
+func checkBatchGetSize(input domain.BatchGetUsersInput, maxKeys int) error {
+	if len(input.IDs)+len(input.Emails) > maxKeys {
+		return paramError("", "at most %d ids and emails can be requested at once", maxKeys)
+	}
+	return nil
+}
+
+func splitListParam(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseFieldsParam returns the sparse fieldset of the comma-separated fields
+// parameter, or nil when it isn't sent. The names are checked by the service.
+func parseFieldsParam(r *http.Request) []string {
+	if !r.URL.Query().Has("fields") {
+		return nil
+	}
+	fields := []string{}
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
 	}
+	return fields
+}
 
-	return limit, offset, orderBy, query, nil
+// parseSortParams returns the sort spec of the sort parameter, or of the
+// order_by one it replaces, and nil when neither is sent.
+func parseSortParams(r *http.Request) (domain.SortSpec, error) {
+	queryParams := r.URL.Query()
+	switch {
+	case queryParams.Has("sort") && queryParams.Has("order_by"):
+		return nil, paramError("order_by", "order_by cannot be combined with sort")
+	case queryParams.Has("sort"):
+		sort, err := domain.ParseSort(queryParams.Get("sort"))
+		if err != nil {
+			return nil, paramError("sort", "%v", err)
+		}
+		return sort, nil
+	case queryParams.Get("order_by") != "":
+		sort, err := domain.ParseOrderBy(queryParams.Get("order_by"))
+		if err != nil {
+			return nil, paramError("order_by", "%v", err)
+		}
+		return sort, nil
+	}
+	return nil, nil
+}
+
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, paramError(name, "invalid %s value: %v", name, err)
+	}
+	return b, nil
+}
+
+// userETag is the strong entity tag of a user, derived from its version.
+func userETag(user domain.User) string {
+	return `"` + strconv.FormatInt(user.Version, 10) + `"`
+}
+
+// parseIfMatch returns the user versions allowed by an If-Match header, a
+// list of entity tags, or nil for "*". Weak tags and tags that aren't user
+// versions never match, as If-Match uses strong comparison, so a list of
+// only those allows no version.
+func parseIfMatch(header string) ([]int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return nil, nil
+	}
+
+	versions := []int64{}
+	for rest := header; rest != ""; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			break
+		}
+		weak := strings.HasPrefix(rest, "W/")
+		rest = strings.TrimPrefix(rest, "W/")
+
+		tag, ok := strings.CutPrefix(rest, `"`)
+		end := strings.IndexByte(tag, '"')
+		if !ok || end < 0 {
+			return nil, fmt.Errorf("If-Match must be \"*\" or a list of entity tags")
+		}
+		tag, rest = tag[:end], tag[end+1:]
+		if rest != "" && !strings.HasPrefix(strings.TrimLeft(rest, " \t"), ",") {
+			return nil, fmt.Errorf("If-Match must be \"*\" or a list of entity tags")
+		}
+
+		if version, err := strconv.ParseInt(tag, 10, 64); err == nil && !weak {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// parseBatchBody reads the users of a batch request: a JSON array, or one
+// JSON object per line for NDJSON content types.
+func parseBatchBody(r *http.Request, maxItems int) ([]domain.CreateUserInput, error) {
+	users := []domain.CreateUserInput{}
+	tooMany := fmt.Errorf("a batch can contain at most %d users", maxItems)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			if len(users) == maxItems {
+				return nil, tooMany
+			}
+
+			var user domain.CreateUserInput
+			if err := json.Unmarshal(text, &user); err != nil {
+				return nil, fmt.Errorf("invalid JSON on line %d", line)
+			}
+			users = append(users, user)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+	default:
+		decoder := json.NewDecoder(r.Body)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("request body must be a JSON array of users")
+		}
+		for decoder.More() {
+			if len(users) == maxItems {
+				return nil, tooMany
+			}
+
+			var user domain.CreateUserInput
+			if err := decoder.Decode(&user); err != nil {
+				return nil, fmt.Errorf("invalid JSON in item %d", len(users))
+			}
+			users = append(users, user)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("request body must be a JSON array of users")
+		}
+	}
+
+	return users, nil
+}
+
+type exportFormat string
+
+const (
+	exportCSV    exportFormat = "csv"
+	exportNDJSON exportFormat = "ndjson"
+)
+
+// exportParams describes an export request: which users, in which format,
+// and which columns under which header names.
+type exportParams struct {
+	input   domain.ExportUsersInput
+	format  exportFormat
+	columns []string
+	headers []string
+}
+
+func parseExportParams(r *http.Request) (params exportParams, err error) {
+	queryParams := r.URL.Query()
+
+	params.input.Sort, err = parseSortParams(r)
+	if err != nil {
+		return exportParams{}, err
+	}
+
+	queryStr := queryParams.Get("query")
+	if queryStr != "" {
+		params.input.Query = &queryStr
+	}
+
+	params.input.IncludeDeleted, err = parseBoolParam(r, "include_deleted")
+	if err != nil {
+		return exportParams{}, err
+	}
+
+	params.input.Filters, err = parseFilters(r)
+	if err != nil {
+		return exportParams{}, err
+	}
+
+	switch format := exportFormat(queryParams.Get("format")); format {
+	case "", exportCSV:
+		params.format = exportCSV
+	case exportNDJSON:
+		params.format = exportNDJSON
+	default:
+		return exportParams{}, paramError("format", "invalid format %q, expected csv or ndjson", format)
+	}
+
+	params.columns = domain.UserFields
+	if columnsStr := queryParams.Get("columns"); columnsStr != "" {
+		params.columns = nil
+		for _, column := range strings.Split(columnsStr, ",") {
+			column = strings.TrimSpace(column)
+			if !slices.Contains(domain.UserFields, column) {
+				return exportParams{}, paramError("columns", "unknown column %q, expected one of %s", column, strings.Join(domain.UserFields, ", "))
+			}
+			params.columns = append(params.columns, column)
+		}
+	}
+	params.input.Fields = params.columns
+
+	names := map[string]string{}
+	if headersStr := queryParams.Get("headers"); headersStr != "" {
+		for _, header := range strings.Split(headersStr, ",") {
+			column, name, ok := strings.Cut(header, ":")
+			column, name = strings.TrimSpace(column), strings.TrimSpace(name)
+			if !ok || name == "" {
+				return exportParams{}, paramError("headers", "invalid header %q, expected column:name", header)
+			}
+			if !slices.Contains(params.columns, column) {
+				return exportParams{}, paramError("headers", "header given for column %q that is not exported", column)
+			}
+			names[column] = name
+		}
+	}
+	params.headers = make([]string, len(params.columns))
+	for i, column := range params.columns {
+		params.headers[i] = column
+		if name, ok := names[column]; ok {
+			params.headers[i] = name
+		}
+	}
+
+	return params, nil
+}
+
+// importFields are the user fields an import file can set.
+var importFields = []string{"email", "first_name", "last_name"}
+
+// parseImportMapping parses the mapping parameter, "CSV column:field,...",
+// into the field each mapped CSV column sets.
+func parseImportMapping(r *http.Request) (map[string]string, error) {
+	mappingStr := r.URL.Query().Get("mapping")
+	if mappingStr == "" {
+		return nil, nil
+	}
+
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(mappingStr, ",") {
+		column, field, ok := strings.Cut(pair, ":")
+		column, field = strings.TrimSpace(column), strings.TrimSpace(field)
+		if !ok || column == "" {
+			return nil, paramError("mapping", "invalid mapping %q, expected column:field", pair)
+		}
+		if !slices.Contains(importFields, field) {
+			return nil, paramError("mapping", "invalid mapping %q, field must be one of %s", pair, strings.Join(importFields, ", "))
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// parseImportBody reads the users of a CSV import, sent either as the "file"
+// part of a multipart form or as the request body. Without a mapping, CSV
+// columns are matched to fields by name and unknown columns are ignored.
+func parseImportBody(r *http.Request, mapping map[string]string, maxRows int) ([]domain.ImportRow, error) {
+	var body io.Reader = r.Body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart upload must contain a file part: %v", err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		// Spreadsheet exports often start with a byte order mark.
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if mapping != nil {
+			columns[i] = mapping[name]
+		} else if field := strings.ToLower(name); slices.Contains(importFields, field) {
+			columns[i] = field
+		}
+	}
+	for _, field := range importFields {
+		if !slices.Contains(columns, field) {
+			return nil, fmt.Errorf("CSV file has no column for %s", field)
+		}
+	}
+
+	rows := []domain.ImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(rows) == maxRows {
+			return nil, fmt.Errorf("an import can contain at most %d rows", maxRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := domain.ImportRow{Line: line}
+		for i, value := range record {
+			switch columns[i] {
+			case "email":
+				row.User.Email = value
+			case "first_name":
+				row.User.FirstName = value
+			case "last_name":
+				row.User.LastName = value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// acceptPatch lists the patch formats PATCH /users/{id} accepts.
+const acceptPatch = domain.MergePatchType + ", " + domain.JSONPatchType
+
+var errUnsupportedPatch = fmt.Errorf("Content-Type must be one of %s", acceptPatch)
+
+// parsePatchBody reads a patch document in the format of its content type.
+func parsePatchBody(r *http.Request) (domain.UserPatch, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case domain.MergePatchType:
+		var patch domain.MergePatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+			return nil, fmt.Errorf("merge patch must be a JSON object")
+		}
+		return patch, nil
+	case domain.JSONPatchType:
+		var patch domain.JSONPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+			return nil, fmt.Errorf("JSON patch must be an array of operations")
+		}
+		return patch, patch.Validate()
+	default:
+		return nil, errUnsupportedPatch
+	}
 }
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    []int64
+		wantErr bool
+	}{
+		{header: "*", want: nil},
+		{header: `"3"`, want: []int64{3}},
+		{header: `"3", "4"`, want: []int64{3, 4}},
+		{header: ` "3" ,"4",, "5" `, want: []int64{3, 4, 5}},
+		{header: `W/"3", "4"`, want: []int64{4}},
+		{header: `W/"3"`, want: []int64{}},
+		{header: `"abc", "a,b", "7"`, want: []int64{7}},
+		{header: `3`, wantErr: true},
+		{header: `"3`, wantErr: true},
+		{header: `"3" "4"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIfMatch(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseIfMatch(%q) = %v, want an error", tt.header, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("parseIfMatch(%q) = %#v, %v, want %#v", tt.header, got, err, tt.want)
+		}
+	}
+}
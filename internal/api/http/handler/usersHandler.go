@@ -2,26 +2,56 @@ package handler
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ExonegeS/REST-API-001/internal/domain"
 	"github.com/gorilla/mux"
 )
 
+// Options configures the HTTP API.
+type Options struct {
+	// AdminToken authorizes admin-only operations when sent as a bearer
+	// token. Admin operations are disabled when it is empty.
+	AdminToken string
+	// ActorProxySecret is the shared secret that an authenticating proxy
+	// sends in the X-Actor-Secret header along with X-Actor. The X-Actor
+	// header is ignored when it is empty or not matched.
+	ActorProxySecret string
+	// RequireIfMatch rejects updates that don't send an If-Match header.
+	RequireIfMatch bool
+	// MaxBatchSize caps the number of users in one batch request.
+	MaxBatchSize int
+	// MaxImportRows caps the number of rows in one CSV import.
+	MaxImportRows int
+	// MaxBatchGetKeys caps the number of ids and emails looked up in one
+	// batch get.
+	MaxBatchGetKeys int
+	// IdempotencyStore keeps the responses of POST /users requests made with
+	// an Idempotency-Key. The header is ignored when it is nil.
+	IdempotencyStore domain.IdempotencyStore
+	// IdempotencyTTL is how long a response is replayed for its key.
+	IdempotencyTTL time.Duration
+}
+
 type ApiServer struct {
-	svc domain.Service
-	srv *http.Server
+	svc  domain.Service
+	srv  *http.Server
+	opts Options
 }
 
-func NewApiServer(svc domain.Service) *ApiServer {
+func NewApiServer(svc domain.Service, opts Options) *ApiServer {
 	return &ApiServer{
-		svc: svc,
+		svc:  svc,
+		opts: opts,
 	}
 }
 
@@ -29,12 +59,26 @@ func (s *ApiServer) Start(listenAddr int) error {
 	router := mux.NewRouter()
 	s.srv = &http.Server{
 		Addr:    fmt.Sprintf(":%v", listenAddr),
-		Handler: router,
+		Handler: requestIDMiddleware(router),
 	}
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
 	router.HandleFunc("/users", s.getUsersHandler).Methods("GET")
+	router.HandleFunc("/users/export", s.exportUsersHandler).Methods("GET")
+	router.HandleFunc("/users:batchGet", s.batchGetUsersHandler).Methods("POST")
 	router.HandleFunc("/users/{id}", s.getUserHandler).Methods("GET")
-	router.HandleFunc("/users", s.createUserHandler).Methods("POST")
+	router.HandleFunc("/users/by-email/{email}", s.getUserByEmailHandler).Methods("GET")
+	router.HandleFunc("/users/by-external-id/{source}/{external_id:.+}", s.getUserByExternalIDHandler).Methods("GET")
+	router.HandleFunc("/users", s.idempotent(s.createUserHandler)).Methods("POST")
+	router.HandleFunc("/users/batch", s.createUsersBatchHandler).Methods("POST")
+	router.HandleFunc("/users/import", s.importUsersHandler).Methods("POST")
 	router.HandleFunc("/users/{id}", s.updateUserHandler).Methods("PUT")
+	router.HandleFunc("/users/by-email/{email}", s.upsertUserHandler).Methods("PUT")
+	router.HandleFunc("/users/{id}", s.patchUserHandler).Methods("PATCH")
+	router.HandleFunc("/users/{id}", s.deleteUserHandler).Methods("DELETE")
+	router.HandleFunc("/users/{id}/restore", s.restoreUserHandler).Methods("POST")
+	router.HandleFunc("/users/{id}/history", s.getUserHistoryHandler).Methods("GET")
+	router.Use(s.actorMiddleware)
 
 	slog.Info(fmt.Sprintf("SERVER STARTED AT ADDRESS %v", listenAddr))
 	return s.srv.ListenAndServe()
@@ -51,33 +95,187 @@ func (s *ApiServer) Stop(ctx context.Context) {
 	}
 }
 
+func (s *ApiServer) isAdmin(r *http.Request) bool {
+	if s.opts.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) == 1
+}
+
+// maxActorLength matches the varchar(255) actor column of the audit trail.
+const maxActorLength = 255
+
+// actorRe matches the X-Actor values recorded in the audit trail, such as
+// user names, emails and service names.
+var actorRe = regexp.MustCompile(`^[A-Za-z0-9._@:/+-]+$`)
+
+// actorMiddleware records who makes the request for the audit trail: "admin"
+// for admin requests, the X-Actor header for requests from the trusted proxy,
+// and "anonymous" for anything else, so that clients can't sign changes with
+// somebody else's name.
+func (s *ApiServer) actorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var actor string
+		if s.isAdmin(r) {
+			actor = "admin"
+		} else if s.fromActorProxy(r) {
+			actor = r.Header.Get("X-Actor")
+			if actor != "" && (len(actor) > maxActorLength || !actorRe.MatchString(actor)) {
+				writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput,
+					fmt.Sprintf("X-Actor must be at most %d letters, digits and . _ @ : / + - characters", maxActorLength))
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(domain.WithActor(r.Context(), actor)))
+	})
+}
+
+// fromActorProxy reports whether r carries the secret of the authenticating
+// proxy, which vouches for its X-Actor header.
+func (s *ApiServer) fromActorProxy(r *http.Request) bool {
+	if s.opts.ActorProxySecret == "" {
+		return false
+	}
+	secret := r.Header.Get("X-Actor-Secret")
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(s.opts.ActorProxySecret)) == 1
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) error {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(v)
 }
 
+// sparseUsersResponse is a users list restricted to a sparse fieldset.
+type sparseUsersResponse struct {
+	Users []domain.PartialUser `json:"users"`
+	*domain.GetUsersResponse
+}
+
+type sparseUserResponse struct {
+	User domain.PartialUser `json:"user"`
+}
+
+func sparseUsers(response *domain.GetUsersResponse, fields []string) sparseUsersResponse {
+	users := make([]domain.PartialUser, len(response.Users))
+	for i, user := range response.Users {
+		users[i] = domain.PartialUser{User: user, Fields: fields}
+	}
+	return sparseUsersResponse{
+		GetUsersResponse: response,
+		Users:            users,
+	}
+}
+
+// sparseBatchGetResponse is a batch get restricted to a sparse fieldset.
+type sparseBatchGetResponse struct {
+	Users []domain.PartialUser `json:"users"`
+	*domain.BatchGetUsersResponse
+}
+
+// batchGetResponse restricts response to fields, unless they are nil.
+func batchGetResponse(response *domain.BatchGetUsersResponse, fields []string) any {
+	if fields == nil {
+		return response
+	}
+	users := make([]domain.PartialUser, len(response.Users))
+	for i, user := range response.Users {
+		users[i] = domain.PartialUser{User: user, Fields: fields}
+	}
+	return sparseBatchGetResponse{
+		BatchGetUsersResponse: response,
+		Users:                 users,
+	}
+}
+
 func (s *ApiServer) getUsersHandler(w http.ResponseWriter, r *http.Request) {
-	limit, offset, orderBy, query, err := parseQueryParams(r)
+	// A list of ids or emails makes the request a batch get.
+	if r.URL.Query().Has("ids") || r.URL.Query().Has("emails") {
+		input, err := parseBatchGetParams(r, s.opts.MaxBatchGetKeys)
+		if err != nil {
+			writeError(w, r, domain.Invalid(err))
+			return
+		}
+
+		response, err := s.svc.BatchGetUsers(r.Context(), input)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeHashed(w, r, batchGetResponse(response, input.Fields))
+		return
+	}
+
+	input, err := parseQueryParams(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		writeError(w, r, domain.Invalid(err))
 		return
 	}
 
-	input := domain.GetUsersInput{
-		Limit:   limit,
-		Offset:  offset,
-		OrderBy: orderBy,
-		Query:   query,
+	response, err := s.svc.GetUsersMany(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	response, err := s.svc.GetUsersMany(context.Background(), input)
+	// The tag covers the whole page, so any change to the matching users,
+	// their order or the total makes it differ.
+	if input.Fields != nil {
+		writeHashed(w, r, sparseUsers(response, input.Fields))
+		return
+	}
+	writeHashed(w, r, response)
+}
+
+func (s *ApiServer) exportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseExportParams(r)
 	if err != nil {
-		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+		writeError(w, r, domain.Invalid(err))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, response)
+	exporter := newUserExporter(w, params)
+	err = s.svc.ExportUsers(r.Context(), params.input, exporter.write)
+	if err == nil {
+		err = exporter.finish()
+	}
+	if err != nil {
+		if !exporter.started {
+			writeError(w, r, err)
+			return
+		}
+		// The status is already sent, so abort the response to keep clients
+		// from mistaking a truncated export for a complete one.
+		slog.Error("export failed", "err", err, "rows", exporter.rows)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *ApiServer) batchGetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input domain.BatchGetUsersInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Invalid JSON body")
+		return
+	}
+	// The fields parameter works as on the other reads, and takes
+	// precedence over fields in the body.
+	if fields := parseFieldsParam(r); fields != nil {
+		input.Fields = fields
+	}
+	if err := checkBatchGetSize(input, s.opts.MaxBatchGetKeys); err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	response, err := s.svc.BatchGetUsers(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, batchGetResponse(response, input.Fields))
 }
 
 func (s *ApiServer) getUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -85,66 +283,301 @@ func (s *ApiServer) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "ID is required"})
+		writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "ID is required")
 		return
 	}
 
-	user, err := s.svc.GetUsersOne(context.Background(), domain.GetUserInput{
-		ID: &id,
-	})
+	w.Header().Set("Accept-Patch", acceptPatch)
+	s.writeUser(w, r, domain.GetUserInput{ID: &id})
+}
+
+func (s *ApiServer) getUserByEmailHandler(w http.ResponseWriter, r *http.Request) {
+	email := mux.Vars(r)["email"]
+	s.writeUser(w, r, domain.GetUserInput{Email: &email})
+}
+
+func (s *ApiServer) getUserByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	source, externalID := vars["source"], vars["external_id"]
+	s.writeUser(w, r, domain.GetUserInput{ExternalSource: &source, ExternalID: &externalID})
+}
+
+// writeUser looks up the user of the key set in input and writes it, with
+// the include_deleted and fields parameters of the request applied.
+func (s *ApiServer) writeUser(w http.ResponseWriter, r *http.Request, input domain.GetUserInput) {
+	var err error
+	input.IncludeDeleted, err = parseBoolParam(r, "include_deleted")
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]any{"error": err.Error()})
+		writeError(w, r, domain.Invalid(err))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, user)
+	fields := parseFieldsParam(r)
+	input.Fields = fields
+	user, err := s.svc.GetUsersOne(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if fields != nil {
+		// A partial user may not hold the version and update time the
+		// validators of the full one are made of.
+		writeHashed(w, r, sparseUserResponse{
+			User: domain.PartialUser{User: user.User, Fields: fields},
+		})
+		return
+	}
+	writeConditional(w, r, user, userETag(user.User), user.User.UpdatedAt)
 }
 
 func (s *ApiServer) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input domain.CreateUserInput
 
 	if r.Body == nil || r.ContentLength == 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Request body is required"})
+		writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Request body is required")
 		return
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
 		if err == io.EOF {
-			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Empty JSON body"})
+			writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Empty JSON body")
 		} else {
-			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body"})
+			writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Invalid JSON body")
 		}
 		slog.Error(err.Error(), "input", input)
 		return
 	}
 
-	response, err := s.svc.CreateUser(context.Background(), input)
+	response, err := s.svc.CreateUser(r.Context(), input)
 	if err != nil {
-		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
+func (s *ApiServer) createUsersBatchHandler(w http.ResponseWriter, r *http.Request) {
+	mode := domain.BatchMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = domain.BatchAtomic
+	}
+
+	users, err := parseBatchBody(r, s.opts.MaxBatchSize)
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	response, err := s.svc.CreateUsersBatch(r.Context(), domain.CreateUsersBatchInput{
+		Users: users,
+		Mode:  mode,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if response.Failed > 0 {
+		status = http.StatusMultiStatus
+		if mode == domain.BatchAtomic {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	writeJSON(w, status, response)
+}
+
+func (s *ApiServer) importUsersHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseBoolParam(r, "dry_run")
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	mapping, err := parseImportMapping(r)
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	rows, err := parseImportBody(r, mapping, s.opts.MaxImportRows)
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	response, err := s.svc.ImportUsers(r.Context(), domain.ImportUsersInput{
+		Rows:   rows,
+		DryRun: dryRun,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// expectedVersions returns the versions allowed by the If-Match header of an
+// update. It writes the error response and returns false when the header is
+// missing but required, or malformed.
+func (s *ApiServer) expectedVersions(w http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	if r.Header.Get("If-Match") == "" {
+		if s.opts.RequireIfMatch {
+			writeErrorStatus(w, r, http.StatusPreconditionRequired, codePreconditionRequired, "If-Match header is required")
+			return nil, false
+		}
+		return nil, true
+	}
+
+	versions, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeErrorStatus(w, r, http.StatusPreconditionFailed, domain.CodeVersionConflict, err.Error())
+		return nil, false
+	}
+	return versions, true
+}
+
 func (s *ApiServer) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input domain.UpdateUserInput
 
 	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON body"})
+		writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Invalid JSON body")
 		return
 	}
 
 	userID := mux.Vars(r)["id"]
 	input.ID = userID
 
-	updatedUser, err := s.svc.UpdateUser(context.Background(), input)
+	var ok bool
+	if input.ExpectedVersions, ok = s.expectedVersions(w, r); !ok {
+		return
+	}
+
+	updatedUser, err := s.svc.UpdateUser(r.Context(), input)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", userETag(updatedUser.User))
 	writeJSON(w, http.StatusOK, updatedUser)
 }
+
+// upsertUserHandler creates the user holding the email of the path, with
+// 201, or replaces its other fields, with 200.
+func (s *ApiServer) upsertUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input domain.UpsertUserInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, domain.CodeInvalidInput, "Invalid JSON body")
+		return
+	}
+	input.Email = mux.Vars(r)["email"]
+
+	response, err := s.svc.UpsertUser(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if response.Created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", userETag(response.User))
+	writeJSON(w, status, response)
+}
+
+func (s *ApiServer) patchUserHandler(w http.ResponseWriter, r *http.Request) {
+	patch, err := parsePatchBody(r)
+	if err == errUnsupportedPatch {
+		w.Header().Set("Accept-Patch", acceptPatch)
+		writeErrorStatus(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	input := domain.PatchUserInput{
+		ID:    mux.Vars(r)["id"],
+		Patch: patch,
+	}
+	var ok bool
+	if input.ExpectedVersions, ok = s.expectedVersions(w, r); !ok {
+		return
+	}
+
+	patchedUser, err := s.svc.PatchUser(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(patchedUser.User))
+	writeJSON(w, http.StatusOK, patchedUser)
+}
+
+func (s *ApiServer) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	hard, err := parseBoolParam(r, "hard")
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	if hard && !s.isAdmin(r) {
+		writeErrorStatus(w, r, http.StatusForbidden, codeForbidden, "hard delete requires admin privileges")
+		return
+	}
+
+	err = s.svc.DeleteUser(r.Context(), domain.DeleteUserInput{
+		ID:   mux.Vars(r)["id"],
+		Hard: hard,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ApiServer) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	restoredUser, err := s.svc.RestoreUser(r.Context(), domain.RestoreUserInput{
+		ID: mux.Vars(r)["id"],
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(restoredUser.User))
+	writeJSON(w, http.StatusOK, restoredUser)
+}
+
+func (s *ApiServer) getUserHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, domain.Invalid(err))
+		return
+	}
+
+	history, err := s.svc.GetUserHistory(r.Context(), domain.GetUserHistoryInput{
+		ID:     mux.Vars(r)["id"],
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+func TestActorMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       Options
+		header     map[string]string
+		want       string
+		wantStatus int
+	}{
+		{
+			name:   "spoofed header without a proxy secret",
+			header: map[string]string{"X-Actor": "alice"},
+			want:   "anonymous",
+		},
+		{
+			name:   "spoofed header with a wrong secret",
+			opts:   Options{ActorProxySecret: "s3cret"},
+			header: map[string]string{"X-Actor": "alice", "X-Actor-Secret": "guess"},
+			want:   "anonymous",
+		},
+		{
+			name:   "spoofed header without the secret",
+			opts:   Options{ActorProxySecret: "s3cret"},
+			header: map[string]string{"X-Actor": "alice"},
+			want:   "anonymous",
+		},
+		{
+			name:   "header from the trusted proxy",
+			opts:   Options{ActorProxySecret: "s3cret"},
+			header: map[string]string{"X-Actor": "alice@example.com", "X-Actor-Secret": "s3cret"},
+			want:   "alice@example.com",
+		},
+		{
+			name:       "invalid header from the trusted proxy",
+			opts:       Options{ActorProxySecret: "s3cret"},
+			header:     map[string]string{"X-Actor": "alice smith", "X-Actor-Secret": "s3cret"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "admin token",
+			opts:   Options{AdminToken: "t0ken"},
+			header: map[string]string{"X-Actor": "alice", "Authorization": "Bearer t0ken"},
+			want:   "admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = domain.ActorFromContext(r.Context())
+			})
+			s := NewApiServer(nil, tt.opts)
+
+			r := httptest.NewRequest(http.MethodPost, "/users", nil)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			s.actorMiddleware(next).ServeHTTP(w, r)
+
+			if tt.wantStatus != 0 {
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got != tt.want {
+				t.Errorf("actor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
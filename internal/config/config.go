@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +13,14 @@ import (
 type Config struct {
 	Server struct {
 		Port int
+		// RequireIfMatch makes updates fail without an If-Match header.
+		RequireIfMatch bool
+	}
+	Storage struct {
+		// Backend is either "postgres" or "memory".
+		Backend string
+		// Fixture is an optional JSON file seeding the memory backend.
+		Fixture string
 	}
 	Database struct {
 		HOST string
@@ -19,10 +28,29 @@ type Config struct {
 		USER string
 		PASS string
 		NAME string
+		// AutoMigrate applies pending migrations on startup.
+		AutoMigrate bool
 	}
 	Logging struct {
 		Level string
 	}
+	Auth struct {
+		AdminToken string
+		// ActorProxySecret authenticates the proxy that sets X-Actor.
+		ActorProxySecret string
+	}
+	Users struct {
+		EmailReuseAfter time.Duration
+		// BatchMaxItems caps the number of users in one batch request.
+		BatchMaxItems int
+		// ImportMaxRows caps the number of rows in one CSV import.
+		ImportMaxRows int
+		// BatchGetMaxKeys caps the number of ids and emails in one batch get.
+		BatchGetMaxKeys int
+		// IdempotencyKeyTTL is how long the response to a request made with
+		// an Idempotency-Key is kept for retries.
+		IdempotencyKeyTTL time.Duration
+	}
 }
 
 func LoadConfig() (*Config, error) {
@@ -39,6 +67,17 @@ func LoadConfig() (*Config, error) {
 	}
 
 	config.Server.Port = port
+	config.Server.RequireIfMatch, _ = strconv.ParseBool(os.Getenv("SERVER_REQUIRE_IF_MATCH"))
+
+	config.Storage.Backend = os.Getenv("STORAGE_BACKEND")
+	switch config.Storage.Backend {
+	case "":
+		config.Storage.Backend = "postgres"
+	case "postgres", "memory":
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND %q, must be memory or postgres", config.Storage.Backend)
+	}
+	config.Storage.Fixture = os.Getenv("STORAGE_FIXTURE")
 
 	config.Database.HOST = os.Getenv("DATABASE_HOST")
 	config.Database.PORT, err = strconv.Atoi(os.Getenv("DATABASE_PORT"))
@@ -49,8 +88,42 @@ func LoadConfig() (*Config, error) {
 	config.Database.USER = os.Getenv("DATABASE_USER")
 	config.Database.PASS = os.Getenv("DATABASE_PASS")
 	config.Database.NAME = os.Getenv("DATABASE_DB")
+	config.Database.AutoMigrate, _ = strconv.ParseBool(os.Getenv("DATABASE_AUTO_MIGRATE"))
 
 	config.Logging.Level = os.Getenv("LOGGING_LEVEL")
 
+	config.Auth.AdminToken = os.Getenv("ADMIN_TOKEN")
+	config.Auth.ActorProxySecret = os.Getenv("ACTOR_PROXY_SECRET")
+
+	config.Users.BatchMaxItems, err = strconv.Atoi(os.Getenv("USERS_BATCH_MAX_ITEMS"))
+	if err != nil || config.Users.BatchMaxItems < 1 {
+		config.Users.BatchMaxItems = 10000
+	}
+
+	config.Users.ImportMaxRows, err = strconv.Atoi(os.Getenv("USERS_IMPORT_MAX_ROWS"))
+	if err != nil || config.Users.ImportMaxRows < 1 {
+		config.Users.ImportMaxRows = 100000
+	}
+
+	config.Users.BatchGetMaxKeys, err = strconv.Atoi(os.Getenv("USERS_BATCH_GET_MAX_KEYS"))
+	if err != nil || config.Users.BatchGetMaxKeys < 1 {
+		config.Users.BatchGetMaxKeys = 100
+	}
+
+	config.Users.IdempotencyKeyTTL = 24 * time.Hour
+	if ttl := os.Getenv("USERS_IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		config.Users.IdempotencyKeyTTL, err = time.ParseDuration(ttl)
+		if err != nil || config.Users.IdempotencyKeyTTL <= 0 {
+			return nil, fmt.Errorf("invalid USERS_IDEMPOTENCY_KEY_TTL %q, must be a positive duration", ttl)
+		}
+	}
+
+	if reuseAfter := os.Getenv("USERS_EMAIL_REUSE_AFTER"); reuseAfter != "" {
+		config.Users.EmailReuseAfter, err = time.ParseDuration(reuseAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid USERS_EMAIL_REUSE_AFTER: %v", err)
+		}
+	}
+
 	return config, nil
 }
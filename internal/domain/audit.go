@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditOperation string
+
+const (
+	AuditCreate  AuditOperation = "create"
+	AuditUpdate  AuditOperation = "update"
+	AuditDelete  AuditOperation = "delete"
+	AuditRestore AuditOperation = "restore"
+	AuditPurge   AuditOperation = "purge"
+)
+
+// FieldChange is the before/after value of one user field.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// AuditRecord describes one change made to a user.
+type AuditRecord struct {
+	ID        int64          `json:"id"`
+	UserID    uuid.UUID      `json:"user_id"`
+	Actor     string         `json:"actor"`
+	Operation AuditOperation `json:"operation"`
+	Changes   []FieldChange  `json:"changes"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// DiffUsers lists the audited fields that differ between before and after.
+// A nil before or after stands for a user that doesn't exist.
+func DiffUsers(before, after *User) []FieldChange {
+	fields := func(u *User) []any {
+		if u == nil {
+			return []any{nil, nil, nil, nil, nil, nil}
+		}
+		var deletedAt any
+		if u.DeletedAt != nil {
+			deletedAt = *u.DeletedAt
+		}
+		return []any{u.Email, u.FirstName, u.LastName, deletedAt, optionalField(u.ExternalSource), optionalField(u.ExternalID)}
+	}
+	names := []string{"email", "first_name", "last_name", "deleted_at", "external_source", "external_id"}
+
+	changes := []FieldChange{}
+	b, a := fields(before), fields(after)
+	for i, name := range names {
+		if beforeTime, ok := b[i].(time.Time); ok {
+			if afterTime, ok := a[i].(time.Time); ok && beforeTime.Equal(afterTime) {
+				continue
+			}
+		} else if b[i] == a[i] {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Before: b[i], After: a[i]})
+	}
+	return changes
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording who is making changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or "anonymous".
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
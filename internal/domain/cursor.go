@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Cursor marks a position in a keyset-paginated list. It holds the sort keys
+// of the boundary row, nil where it has no value, plus its ID as a
+// tie-breaker, and is handed to clients as an opaque string.
+type Cursor struct {
+	Sort     string    `json:"o"`
+	Keys     []*string `json:"k"`
+	ID       uuid.UUID `json:"i"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if cursor.ID == uuid.Nil || len(cursor.Keys) == 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
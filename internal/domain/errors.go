@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The kinds of errors the service reports. Errors of a kind wrap its
+// sentinel, so callers classify them with errors.Is.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Stable, machine-readable error codes.
+const (
+	CodeInvalidInput    = "invalid_input"
+	CodeUserNotFound    = "user_not_found"
+	CodeEmailInUse      = "email_in_use"
+	CodeExternalIDInUse = "external_id_in_use"
+	CodeVersionConflict = "version_conflict"
+	CodeUnavailable     = "storage_unavailable"
+	CodeInternal        = "internal_error"
+)
+
+// Error is an error of a kind, with a code for clients and optionally the
+// error that caused it.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Err     error
+}
+
+// NewError returns an Error of kind with a formatted message.
+func NewError(kind error, code string, format string, args ...any) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// Invalid marks err as a validation error.
+func Invalid(err error) error {
+	return &Error{
+		Kind:    ErrValidation,
+		Code:    CodeInvalidInput,
+		Message: "input validation error",
+		Err:     err,
+	}
+}
+
+// Unavailable marks err as a failure to reach a dependency, such as the
+// database, that may succeed when retried.
+func Unavailable(message string, err error) error {
+	return &Error{
+		Kind:    ErrUnavailable,
+		Code:    CodeUnavailable,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// UserNotFound is returned when the user looked up does not exist.
+func UserNotFound(format string, args ...any) error {
+	return NewError(ErrNotFound, CodeUserNotFound, format, args...)
+}
+
+// EmailInUse is returned when the email of a user is held by another one.
+func EmailInUse(format string, args ...any) error {
+	return NewError(ErrConflict, CodeEmailInUse, format, args...)
+}
+
+// ExternalIDInUse is returned when the external id of a user is held by
+// another one from the same source.
+func ExternalIDInUse(format string, args ...any) error {
+	return NewError(ErrConflict, CodeExternalIDInUse, format, args...)
+}
+
+// ErrorCode returns the code of err, or CodeInternal for errors without one.
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if errors.Is(err, ErrValidation) {
+		return CodeInvalidInput
+	}
+	return CodeInternal
+}
+
+// Codes of field validation failures.
+const (
+	FieldRequired   = "required"
+	FieldInvalid    = "invalid"
+	FieldOutOfRange = "out_of_range"
+)
+
+// FieldError is a validation failure of one input field.
+type FieldError struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+// ValidationErrors collects the failures of every invalid field of an
+// input, so they can all be reported at once. It is of kind ErrValidation.
+type ValidationErrors []FieldError
+
+// Add records a failure of field.
+func (e *ValidationErrors) Add(field, code, format string, args ...any) {
+	*e = append(*e, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Err returns e as an error, or nil if no field failed.
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ErrVersionConflict is returned when an update was made against a stale
+// version of a user.
+var ErrVersionConflict error = NewError(ErrConflict, CodeVersionConflict, "user has been modified since it was last read")
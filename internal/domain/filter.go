@@ -0,0 +1,209 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/idna"
+)
+
+// FilterOp is the operator of a filter on the users list.
+type FilterOp string
+
+const (
+	FilterEq     FilterOp = "eq"
+	FilterGt     FilterOp = "gt"
+	FilterGte    FilterOp = "gte"
+	FilterLt     FilterOp = "lt"
+	FilterLte    FilterOp = "lte"
+	FilterPrefix FilterOp = "prefix"
+	FilterIn     FilterOp = "in"
+	FilterNotIn  FilterOp = "not_in"
+)
+
+// maxFilterValues caps the number of values of an in or not_in filter.
+const maxFilterValues = 100
+
+// Filter is one condition on the users of a list, such as
+// created_at[gte]=2024-01-01. The filters of a list must all hold.
+//
+// Value is typed after the field: a time.Time for timestamps, a []uuid.UUID
+// for id lists and a string otherwise.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value any
+}
+
+// UserFilterOps is the allowlist of the fields the users list can be
+// filtered on, with the operators each of them supports.
+var UserFilterOps = map[string][]FilterOp{
+	"id":           {FilterIn, FilterNotIn},
+	"email_domain": {FilterEq},
+	"first_name":   {FilterEq, FilterPrefix},
+	"last_name":    {FilterEq, FilterPrefix},
+	"created_at":   {FilterGt, FilterGte, FilterLt, FilterLte},
+	"updated_at":   {FilterGt, FilterGte, FilterLt, FilterLte},
+}
+
+// ParseFilter builds the filter of field and op from the text of its value.
+// An empty op means eq.
+func ParseFilter(field, op, value string) (Filter, error) {
+	filter := Filter{Field: field, Op: FilterOp(op)}
+	if filter.Op == "" {
+		filter.Op = FilterEq
+	}
+
+	ops, ok := UserFilterOps[field]
+	if !ok {
+		return Filter{}, fmt.Errorf("unknown filter field %q, must be one of: %s", field, strings.Join(filterFields(), ", "))
+	}
+	if !slices.Contains(ops, filter.Op) {
+		return Filter{}, fmt.Errorf("unsupported operator %q for %s, must be one of: %s", filter.Op, field, joinOps(ops))
+	}
+
+	switch field {
+	case "id":
+		ids := []uuid.UUID{}
+		for _, s := range strings.Split(value, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(s))
+			if err != nil {
+				return Filter{}, fmt.Errorf("invalid id %q", s)
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) > maxFilterValues {
+			return Filter{}, fmt.Errorf("at most %d ids can be listed", maxFilterValues)
+		}
+		filter.Value = ids
+	case "created_at", "updated_at":
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return Filter{}, err
+		}
+		filter.Value = t
+	case "email_domain":
+		domain, err := idna.Lookup.ToASCII(normalizeText(value))
+		if err != nil || domain == "" {
+			return Filter{}, fmt.Errorf("invalid email domain %q", value)
+		}
+		filter.Value = domain
+	default:
+		text := normalizeText(value)
+		if text == "" {
+			return Filter{}, fmt.Errorf("%s cannot be empty", field)
+		}
+		filter.Value = text
+	}
+
+	return filter, nil
+}
+
+// parseFilterTime accepts RFC 3339 timestamps and plain dates, which stand
+// for midnight UTC.
+func parseFilterTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, must be an RFC 3339 timestamp or a YYYY-MM-DD date", value)
+}
+
+func filterFields() []string {
+	fields := make([]string, 0, len(UserFilterOps))
+	for field := range UserFilterOps {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+func joinOps(ops []FilterOp) string {
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+	return strings.Join(names, ", ")
+}
+
+// String returns the query parameter of the filter, such as created_at[gte].
+func (f Filter) String() string {
+	return f.Field + "[" + string(f.Op) + "]"
+}
+
+// validate checks that the filter is allowed and that its value has the
+// type of its field.
+func (f Filter) validate() error {
+	ops, ok := UserFilterOps[f.Field]
+	if !ok {
+		return fmt.Errorf("unknown filter field %q", f.Field)
+	}
+	if !slices.Contains(ops, f.Op) {
+		return fmt.Errorf("unsupported operator %q for %s", f.Op, f.Field)
+	}
+
+	switch f.Field {
+	case "id":
+		_, ok = f.Value.([]uuid.UUID)
+	case "created_at", "updated_at":
+		_, ok = f.Value.(time.Time)
+	default:
+		_, ok = f.Value.(string)
+	}
+	if !ok {
+		return fmt.Errorf("invalid value of type %T for %s", f.Value, f.Field)
+	}
+	return nil
+}
+
+// MatchesFilters reports whether user matches every one of filters.
+func MatchesFilters(user User, filters []Filter) bool {
+	for _, filter := range filters {
+		if !filter.Matches(user) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches evaluates the filter on user. Prefixes match case-insensitively.
+func (f Filter) Matches(user User) bool {
+	switch f.Field {
+	case "id":
+		found := slices.Contains(f.Value.([]uuid.UUID), user.ID)
+		return found == (f.Op == FilterIn)
+	case "email_domain":
+		domain := user.Email[strings.LastIndexByte(user.Email, '@')+1:]
+		return strings.EqualFold(domain, f.Value.(string))
+	case "first_name", "last_name":
+		name, value := user.FirstName, f.Value.(string)
+		if f.Field == "last_name" {
+			name = user.LastName
+		}
+		if f.Op == FilterPrefix {
+			return strings.HasPrefix(strings.ToLower(name), strings.ToLower(value))
+		}
+		return name == value
+	case "created_at", "updated_at":
+		t, value := user.CreatedAt, f.Value.(time.Time)
+		if f.Field == "updated_at" {
+			t = user.UpdatedAt
+		}
+		switch f.Op {
+		case FilterGt:
+			return t.After(value)
+		case FilterGte:
+			return !t.Before(value)
+		case FilterLt:
+			return t.Before(value)
+		case FilterLte:
+			return !t.After(value)
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// IdempotentResponse is the stored outcome of a request made with an
+// Idempotency-Key.
+type IdempotentResponse struct {
+	Key string
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	// Completed is false while the first request is still being handled.
+	Completed bool
+	Status    int
+	Header    http.Header
+	Body      []byte
+	ExpiresAt time.Time
+}
+
+// IdempotencyStore keeps the responses of requests made with an
+// Idempotency-Key, so that retries can be answered without redoing them.
+type IdempotencyStore interface {
+	// Begin claims key for a request with fingerprint until leaseUntil,
+	// after which an unfinished claim can be taken over. It returns nil if
+	// the key is now claimed by the caller, or the live response of an
+	// earlier request holding the key.
+	Begin(ctx context.Context, key, fingerprint string, leaseUntil time.Time) (*IdempotentResponse, error)
+	// Extend moves the lease of an unfinished claim of key for fingerprint
+	// to leaseUntil, for requests that outlast it.
+	Extend(ctx context.Context, key, fingerprint string, leaseUntil time.Time) error
+	// Complete stores the response of the request that claimed its key,
+	// keeping the key until response.ExpiresAt.
+	Complete(ctx context.Context, response IdempotentResponse) error
+	// Release frees a claimed key, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+	// DeleteExpired removes the responses whose key has expired.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
@@ -0,0 +1,366 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Media types of the supported patch formats.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// CodePatchConflict is the code of patches that can't be applied to the
+// current state of a user, including failed JSON Patch tests.
+const CodePatchConflict = "patch_conflict"
+
+// UserPatch is a patch document for the JSON representation of a user.
+type UserPatch interface {
+	// Apply returns the update that patching user amounts to.
+	Apply(user User) (*UpdateUserInput, error)
+}
+
+// MergePatch is an RFC 7396 JSON Merge Patch. A null member removes the
+// field, which fails for the required fields of a user.
+type MergePatch map[string]any
+
+func (p MergePatch) Apply(user User) (*UpdateUserInput, error) {
+	doc := mergePatch(userDocument(user), map[string]any(p)).(map[string]any)
+	return patchedUser(user, doc)
+}
+
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// PatchOperation is one operation of a JSON Patch.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is an RFC 6902 JSON Patch. Its operations apply in order, and
+// the patch fails as a whole if any of them fails.
+type JSONPatch []PatchOperation
+
+// Validate checks that the operations are well-formed.
+func (p JSONPatch) Validate() error {
+	var errs ValidationErrors
+	for i, op := range p {
+		field := strconv.Itoa(i)
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				errs.Add(field+"/value", FieldRequired, "operation %d: value is required", i)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				errs.Add(field+"/from", FieldInvalid, "operation %d: %v", i, err)
+			}
+		case "remove":
+		default:
+			errs.Add(field+"/op", FieldInvalid, "operation %d: unknown op %q", i, op.Op)
+		}
+		if _, err := parsePointer(op.Path); err != nil {
+			errs.Add(field+"/path", FieldInvalid, "operation %d: %v", i, err)
+		}
+	}
+	return errs.Err()
+}
+
+func (p JSONPatch) Apply(user User) (*UpdateUserInput, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var doc any = userDocument(user)
+	for i, op := range p {
+		var err error
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, NewError(ErrConflict, CodePatchConflict, "operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	patched, ok := doc.(map[string]any)
+	if !ok {
+		return nil, NewError(ErrValidation, CodeInvalidInput, "patched user must be a JSON object")
+	}
+	return patchedUser(user, patched)
+}
+
+func applyOperation(doc any, op PatchOperation) (any, error) {
+	path, _ := parsePointer(op.Path)
+
+	var value any
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, _, err := pointerRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "move":
+		from, _ := parsePointer(op.From)
+		if len(path) > len(from) && slices.Equal(path[:len(from)], from) {
+			return nil, fmt.Errorf("cannot move a value into itself")
+		}
+		doc, moved, err := pointerRemove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, moved)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		copied, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, deepCopy(copied))
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses the token of an array element. With end set, "-" and
+// len(array) address the position after the last element.
+func arrayIndex(token string, array []any, end bool) (int, error) {
+	if end && token == "-" {
+		return len(array), nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > len(array) || (i == len(array) && !end) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, node, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns doc with value added at path.
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		child, err := pointerAdd(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []any:
+		i, err := arrayIndex(token, node, len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return slices.Insert(node, i, value), nil
+		}
+		child, err := pointerAdd(node[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("path not found")
+}
+
+// pointerRemove returns doc without the value at path, and that value.
+func pointerRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found")
+		}
+		if len(rest) == 0 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := pointerRemove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+	case []any:
+		i, err := arrayIndex(token, node, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := node[i]
+			return slices.Delete(node, i, i+1), removed, nil
+		}
+		child, removed, err := pointerRemove(node[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[i] = child
+		return node, removed, nil
+	}
+	return nil, nil, fmt.Errorf("path not found")
+}
+
+func deepCopy(value any) any {
+	data, _ := json.Marshal(value)
+	var copied any
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// userDocument returns the JSON representation of user as generic values.
+func userDocument(user User) map[string]any {
+	data, _ := json.Marshal(user)
+	var doc map[string]any
+	json.Unmarshal(data, &doc)
+	return doc
+}
+
+// writableUserFields are the fields of a user that patches can change.
+var writableUserFields = []string{"email", "first_name", "last_name", "external_source", "external_id"}
+
+// patchedUser turns the patched document of user into an update. The other
+// fields of the document must be left as they are.
+func patchedUser(user User, doc map[string]any) (*UpdateUserInput, error) {
+	original := userDocument(user)
+	input := &UpdateUserInput{ID: user.ID.String()}
+	fields := map[string]*string{
+		"email":      &input.Email,
+		"first_name": &input.FirstName,
+		"last_name":  &input.LastName,
+		// The external id is optional: removing it clears it.
+		"external_source": &input.ExternalSource,
+		"external_id":     &input.ExternalID,
+	}
+
+	var errs ValidationErrors
+	for _, key := range slices.Sorted(maps.Keys(doc)) {
+		value := doc[key]
+		if field, ok := fields[key]; ok {
+			s, ok := value.(string)
+			if !ok {
+				errs.Add(key, FieldInvalid, "%s must be a string", key)
+			}
+			*field = s
+			continue
+		}
+
+		originalValue, ok := original[key]
+		switch {
+		case !slices.Contains(UserFields, key):
+			errs.Add(key, FieldInvalid, "unknown field %s", key)
+		case !ok || !reflect.DeepEqual(originalValue, value):
+			errs.Add(key, FieldInvalid, "%s is read-only", key)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(original)) {
+		if _, ok := doc[key]; !ok && !slices.Contains(writableUserFields, key) {
+			errs.Add(key, FieldInvalid, "%s is read-only", key)
+		}
+	}
+
+	return input, errs.Err()
+}
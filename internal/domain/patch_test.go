@@ -0,0 +1,356 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func patchTestUser() User {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return User{
+		ID:        uuid.MustParse("6f1c2f4e-8c1a-4a8e-9d55-0a4f0e6f6c11"),
+		Email:     "john@example.com",
+		FirstName: "John",
+		LastName:  "Smith",
+		CreatedAt: created,
+		UpdatedAt: created,
+		Version:   3,
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+		wantErr bool
+	}{
+		{pointer: "", want: []string{}},
+		{pointer: "/", want: []string{""}},
+		{pointer: "/first_name", want: []string{"first_name"}},
+		{pointer: "/a~1b/c~0d", want: []string{"a/b", "c~d"}},
+		{pointer: "/~01", want: []string{"~1"}},
+		{pointer: "first_name", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePointer(%q) = %q, want an error", tt.pointer, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parsePointer(%q) = %q, %v, want %q", tt.pointer, got, err, tt.want)
+		}
+	}
+}
+
+func TestMergePatchApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    UpdateUserInput
+		wantErr []string
+	}{
+		{
+			name:  "replaces a name",
+			patch: `{"first_name": "Johnny"}`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "Johnny", LastName: "Smith"},
+		},
+		{
+			name:  "sets the external id",
+			patch: `{"external_source": "okta", "external_id": "00u1"}`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John", LastName: "Smith", ExternalSource: "okta", ExternalID: "00u1"},
+		},
+		{
+			name:  "null removes a writable field",
+			patch: `{"last_name": null}`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John"},
+		},
+		{
+			name:  "unchanged read-only field",
+			patch: `{"version": 3}`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John", LastName: "Smith"},
+		},
+		{
+			name:    "changed read-only field",
+			patch:   `{"version": 4}`,
+			wantErr: []string{"version"},
+		},
+		{
+			name:    "removed read-only field",
+			patch:   `{"created_at": null}`,
+			wantErr: []string{"created_at"},
+		},
+		{
+			name:    "unknown field",
+			patch:   `{"nickname": "Jo"}`,
+			wantErr: []string{"nickname"},
+		},
+		{
+			name:    "non-string value",
+			patch:   `{"email": 42}`,
+			wantErr: []string{"email"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch MergePatch
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			user := patchTestUser()
+			got, err := patch.Apply(user)
+			if tt.wantErr != nil {
+				assertFieldErrors(t, err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			tt.want.ID = user.ID.String()
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr []string
+	}{
+		{
+			name:  "well-formed operations",
+			patch: `[{"op": "test", "path": "/email", "value": "a@b.co"}, {"op": "remove", "path": "/last_name"}, {"op": "copy", "from": "/first_name", "path": "/last_name"}]`,
+		},
+		{
+			name:    "unknown op",
+			patch:   `[{"op": "rename", "path": "/email"}]`,
+			wantErr: []string{"0/op"},
+		},
+		{
+			name:    "missing value",
+			patch:   `[{"op": "replace", "path": "/email"}]`,
+			wantErr: []string{"0/value"},
+		},
+		{
+			name:    "invalid path",
+			patch:   `[{"op": "remove", "path": "email"}]`,
+			wantErr: []string{"0/path"},
+		},
+		{
+			name:    "invalid from",
+			patch:   `[{"op": "move", "from": "first_name", "path": "/last_name"}]`,
+			wantErr: []string{"0/from"},
+		},
+		{
+			name:    "every invalid operation is reported",
+			patch:   `[{"op": "add", "path": "/email"}, {"op": "remove", "path": "/last_name"}, {"op": "copy", "from": "x", "path": "y"}]`,
+			wantErr: []string{"0/value", "2/from", "2/path"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch JSONPatch
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			err := patch.Validate()
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			assertFieldErrors(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  UpdateUserInput
+		// wantConflict expects a failed operation, wantErr invalid fields.
+		wantConflict bool
+		wantErr      []string
+	}{
+		{
+			name:  "replace",
+			patch: `[{"op": "replace", "path": "/first_name", "value": "Johnny"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "Johnny", LastName: "Smith"},
+		},
+		{
+			name:  "add sets a missing writable field",
+			patch: `[{"op": "add", "path": "/external_source", "value": "okta"}, {"op": "add", "path": "/external_id", "value": "00u1"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John", LastName: "Smith", ExternalSource: "okta", ExternalID: "00u1"},
+		},
+		{
+			name:  "move",
+			patch: `[{"op": "move", "from": "/first_name", "path": "/last_name"}, {"op": "add", "path": "/first_name", "value": "Jo"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "Jo", LastName: "John"},
+		},
+		{
+			name:  "copy",
+			patch: `[{"op": "copy", "from": "/first_name", "path": "/last_name"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John", LastName: "John"},
+		},
+		{
+			name:  "passing test",
+			patch: `[{"op": "test", "path": "/version", "value": 3}, {"op": "replace", "path": "/last_name", "value": "Smyth"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John", LastName: "Smyth"},
+		},
+		{
+			name:  "remove of a writable field",
+			patch: `[{"op": "remove", "path": "/last_name"}]`,
+			want:  UpdateUserInput{Email: "john@example.com", FirstName: "John"},
+		},
+		{
+			name:    "malformed operation",
+			patch:   `[{"op": "replace", "path": "/first_name"}]`,
+			wantErr: []string{"0/value"},
+		},
+		{
+			name:         "add below a missing member",
+			patch:        `[{"op": "add", "path": "/profile/nickname", "value": "Jo"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "add into a string",
+			patch:        `[{"op": "add", "path": "/first_name/0", "value": "J"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "remove of a missing member",
+			patch:        `[{"op": "remove", "path": "/deleted_at"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "remove of the whole document",
+			patch:        `[{"op": "remove", "path": ""}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "replace of a missing member",
+			patch:        `[{"op": "replace", "path": "/nickname", "value": "Jo"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "move from a missing member",
+			patch:        `[{"op": "move", "from": "/nickname", "path": "/first_name"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "move into itself",
+			patch:        `[{"op": "move", "from": "/first_name", "path": "/first_name/x"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "copy from a missing member",
+			patch:        `[{"op": "copy", "from": "/nickname", "path": "/first_name"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "failing test",
+			patch:        `[{"op": "test", "path": "/version", "value": 2}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "test of a missing member",
+			patch:        `[{"op": "test", "path": "/nickname", "value": "Jo"}]`,
+			wantConflict: true,
+		},
+		{
+			name:         "failure undoes the earlier operations",
+			patch:        `[{"op": "replace", "path": "/first_name", "value": "Johnny"}, {"op": "test", "path": "/first_name", "value": "John"}]`,
+			wantConflict: true,
+		},
+		{
+			name:    "replace of a read-only field",
+			patch:   `[{"op": "replace", "path": "/id", "value": "00000000-0000-0000-0000-000000000000"}]`,
+			wantErr: []string{"id"},
+		},
+		{
+			name:    "remove of a read-only field",
+			patch:   `[{"op": "remove", "path": "/version"}]`,
+			wantErr: []string{"version"},
+		},
+		{
+			name:    "add of an unknown field",
+			patch:   `[{"op": "add", "path": "/nickname", "value": "Jo"}]`,
+			wantErr: []string{"nickname"},
+		},
+		{
+			name:    "document replaced by a non-object",
+			patch:   `[{"op": "replace", "path": "", "value": "john"}]`,
+			wantErr: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch JSONPatch
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			user := patchTestUser()
+			got, err := patch.Apply(user)
+			switch {
+			case tt.wantConflict:
+				if !errors.Is(err, ErrConflict) || ErrorCode(err) != CodePatchConflict {
+					t.Fatalf("Apply() error = %v, want a %s", err, CodePatchConflict)
+				}
+				return
+			case tt.wantErr != nil:
+				if len(tt.wantErr) == 0 {
+					if !errors.Is(err, ErrValidation) {
+						t.Fatalf("Apply() error = %v, want a validation error", err)
+					}
+					return
+				}
+				assertFieldErrors(t, err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			tt.want.ID = user.ID.String()
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// assertFieldErrors checks that err is a validation error of exactly fields.
+func assertFieldErrors(t *testing.T, err error, fields []string) {
+	t.Helper()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error = %v, want validation errors of %q", err, fields)
+	}
+	got := make([]string, len(errs))
+	for i, fieldErr := range errs {
+		got[i] = fieldErr.Field
+	}
+	if !slices.Equal(got, fields) {
+		t.Errorf("invalid fields = %q, want %q", got, fields)
+	}
+}
@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFieldLength is the length limit of the text fields of a user, matching
+// their varchar(255) columns.
+const maxFieldLength = 255
+
+// maxExternalSourceLength matches the varchar(64) external_source column.
+const maxExternalSourceLength = 64
+
+var emailRe = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.([a-zA-Z]{2,}|xn--[a-zA-Z0-9-]+)$`)
+
+// externalSourceRe matches the names of the systems external ids come from,
+// such as "okta" or "billing-v2".
+var externalSourceRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// normalizeText brings user input to the form it is stored in: NFC, without
+// surrounding white space.
+func normalizeText(s string) string {
+	return strings.TrimSpace(norm.NFC.String(s))
+}
+
+// CanonicalEmail returns the form emails are stored in: the local part as
+// given and the domain lower-cased, with international domains in punycode.
+// Emails whose domain is not a valid IDN are returned unchanged, to fail
+// validation.
+func CanonicalEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return email
+	}
+	return email[:at+1] + domain
+}
+
+// EmailKey returns the identity of an email: two emails with the same key
+// belong to the same user. It matches the lower(email) unique index.
+func EmailKey(email string) string {
+	return strings.ToLower(email)
+}
+
+// validateUserFields normalizes the writable fields of a user in place and
+// checks them. Every input that sets them goes through it, so that create
+// and update share one rule set.
+func validateUserFields(errs *ValidationErrors, email, firstName, lastName *string) {
+	*email = CanonicalEmail(normalizeText(*email))
+	*firstName = normalizeText(*firstName)
+	*lastName = normalizeText(*lastName)
+
+	validateEmail(errs, "email", *email)
+	validateName(errs, "first_name", *firstName)
+	validateName(errs, "last_name", *lastName)
+}
+
+func validateEmail(errs *ValidationErrors, field, email string) {
+	switch {
+	case email == "":
+		errs.Add(field, FieldRequired, "%s is required", field)
+	case utf8.RuneCountInString(email) > maxFieldLength:
+		errs.Add(field, FieldOutOfRange, "%s must be at most %d characters", field, maxFieldLength)
+	case !emailRe.MatchString(email):
+		errs.Add(field, FieldInvalid, "invalid email format")
+	}
+}
+
+func validateName(errs *ValidationErrors, field, name string) {
+	switch {
+	case name == "":
+		errs.Add(field, FieldRequired, "%s is required", field)
+	case !utf8.ValidString(name):
+		errs.Add(field, FieldInvalid, "%s must be valid UTF-8", field)
+	case utf8.RuneCountInString(name) > maxFieldLength:
+		errs.Add(field, FieldOutOfRange, "%s must be at most %d characters", field, maxFieldLength)
+	case strings.ContainsFunc(name, unicode.IsControl):
+		errs.Add(field, FieldInvalid, "%s must not contain control characters", field)
+	}
+}
+
+// validateExternalID normalizes the external source and id of a user in
+// place and checks them. They are optional, but only together.
+func validateExternalID(errs *ValidationErrors, source, id *string) {
+	*source = strings.ToLower(normalizeText(*source))
+	*id = normalizeText(*id)
+
+	switch {
+	case *source == "" && *id == "":
+		return
+	case *source == "":
+		errs.Add("external_source", FieldRequired, "external_source is required with external_id")
+	case *id == "":
+		errs.Add("external_id", FieldRequired, "external_id is required with external_source")
+	}
+
+	switch {
+	case *source == "":
+	case len(*source) > maxExternalSourceLength:
+		errs.Add("external_source", FieldOutOfRange, "external_source must be at most %d characters", maxExternalSourceLength)
+	case !externalSourceRe.MatchString(*source):
+		errs.Add("external_source", FieldInvalid, "external_source must consist of lower-case letters, digits, - and _")
+	}
+	switch {
+	case *id == "":
+	case !utf8.ValidString(*id):
+		errs.Add("external_id", FieldInvalid, "external_id must be valid UTF-8")
+	case utf8.RuneCountInString(*id) > maxFieldLength:
+		errs.Add("external_id", FieldOutOfRange, "external_id must be at most %d characters", maxFieldLength)
+	case strings.ContainsFunc(*id, unicode.IsControl):
+		errs.Add("external_id", FieldInvalid, "external_id must not contain control characters")
+	}
+}
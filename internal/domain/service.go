@@ -3,112 +3,453 @@ package domain
 import (
 	"context"
 	"fmt"
-	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 type List[T any] struct {
-	Elements []T
-	Total    int64
+	Elements   []T
+	Total      int64
+	NextCursor string
+	PrevCursor string
 }
 
 type (
 	GetUsersInput struct {
-		Query   *string
-		OrderBy *string
-		Limit   int
-		Offset  int
+		Query          *string
+		Cursor         *string
+		Limit          int
+		Offset         int
+		IncludeDeleted bool
+		// Sort is the order of the list, or nil for DefaultSort.
+		Sort SortSpec
+		// Filters must all hold for the listed users.
+		Filters []Filter
+		// Fields is the sparse fieldset to return, or nil for every field.
+		Fields []string
 	}
 	GetUsersResponse struct {
-		Users []User `json:"users"`
-		Total int64  `json:"total"`
+		Users      []User `json:"users"`
+		Total      int64  `json:"total"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+	}
+	ExportUsersInput struct {
+		Query          *string
+		Sort           SortSpec
+		IncludeDeleted bool
+		// Filters works as in GetUsersInput.
+		Filters []Filter
+		// Fields are the exported fields, which are the only ones read.
+		Fields []string
 	}
+	// GetUserInput looks up a user by exactly one key: its id, its email or
+	// its external source and id.
 	GetUserInput struct {
-		ID *string `json:"id"`
+		ID             *string `json:"id"`
+		Email          *string `json:"email"`
+		ExternalSource *string `json:"external_source"`
+		ExternalID     *string `json:"external_id"`
+		IncludeDeleted bool    `json:"include_deleted"`
+		// Fields works as in GetUsersInput.
+		Fields []string `json:"fields"`
 	}
 	GetUserResponse struct {
 		User User `json:"user"`
 	}
+	// BatchGetUsersInput looks up users by id and by email at once.
+	BatchGetUsersInput struct {
+		IDs            []string `json:"ids"`
+		Emails         []string `json:"emails"`
+		IncludeDeleted bool     `json:"include_deleted"`
+		// Fields works as in GetUsersInput.
+		Fields []string `json:"fields"`
+	}
+	BatchGetUsersResponse struct {
+		Users []User `json:"users"`
+		// Missing lists the requested ids and emails, as they were given,
+		// that match no user.
+		Missing []string `json:"missing"`
+	}
 	CreateUserInput struct {
-		Email     string `json:"email"`
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
+		Email          string `json:"email"`
+		FirstName      string `json:"first_name"`
+		LastName       string `json:"last_name"`
+		ExternalSource string `json:"external_source"`
+		ExternalID     string `json:"external_id"`
+	}
+	CreateUsersBatchInput struct {
+		Users []CreateUserInput
+		Mode  BatchMode
+	}
+	BatchItemResult struct {
+		Index  int             `json:"index"`
+		Status BatchItemStatus `json:"status"`
+		ID     *uuid.UUID      `json:"id,omitempty"`
+		Error  string          `json:"error,omitempty"`
+	}
+	CreateUsersBatchResponse struct {
+		Results []BatchItemResult `json:"results"`
+		Created int               `json:"created"`
+		Failed  int               `json:"failed"`
+	}
+	ImportUsersInput struct {
+		Rows   []ImportRow
+		DryRun bool
+	}
+	// ImportRow is a user read from an import file, with the line it was
+	// read from.
+	ImportRow struct {
+		Line int
+		User CreateUserInput
 	}
+	ImportRowResult struct {
+		Line   int             `json:"line"`
+		Status ImportRowStatus `json:"status"`
+		ID     *uuid.UUID      `json:"id,omitempty"`
+		Error  string          `json:"error,omitempty"`
+	}
+	ImportUsersResponse struct {
+		Results   []ImportRowResult `json:"results"`
+		Created   int               `json:"created"`
+		Updated   int               `json:"updated"`
+		Unchanged int               `json:"unchanged"`
+		Rejected  int               `json:"rejected"`
+		Failed    int               `json:"failed"`
+		DryRun    bool              `json:"dry_run"`
+	}
+	// UpdateUserInput replaces every writable field of a user.
 	UpdateUserInput struct {
-		ID        string  `json"id"`
-		Email     *string `json:"email"`
-		FirstName *string `json:"first_name"`
-		LastName  *string `json:"last_name"`
+		ID             string `json:"id"`
+		Email          string `json:"email"`
+		FirstName      string `json:"first_name"`
+		LastName       string `json:"last_name"`
+		ExternalSource string `json:"external_source"`
+		ExternalID     string `json:"external_id"`
+		// ExpectedVersions, when not nil, makes the update fail with
+		// ErrVersionConflict unless the user is still at one of them.
+		ExpectedVersions []int64 `json:"-"`
+	}
+	// UpsertUserInput creates the user holding Email, or replaces the other
+	// writable fields of the active one.
+	UpsertUserInput struct {
+		Email          string `json:"-"`
+		FirstName      string `json:"first_name"`
+		LastName       string `json:"last_name"`
+		ExternalSource string `json:"external_source"`
+		ExternalID     string `json:"external_id"`
+	}
+	UpsertUserResponse struct {
+		User    User `json:"user"`
+		Created bool `json:"created"`
+		// Changed is false when the user already had the given fields.
+		Changed bool `json:"changed"`
+	}
+	PatchUserInput struct {
+		ID    string
+		Patch UserPatch
+		// ExpectedVersions works as in UpdateUserInput.
+		ExpectedVersions []int64
 	}
+	DeleteUserInput struct {
+		ID   string `json:"id"`
+		Hard bool   `json:"hard"`
+	}
+	RestoreUserInput struct {
+		ID string `json:"id"`
+	}
+	GetUserHistoryInput struct {
+		ID     string
+		Limit  int
+		Offset int
+	}
+	GetUserHistoryResponse struct {
+		History []AuditRecord `json:"history"`
+		Total   int64         `json:"total"`
+	}
+)
+
+// BatchMode decides what happens to the valid items of a batch when others
+// fail.
+type BatchMode string
+
+const (
+	// BatchAtomic creates all items or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort creates every item that can be created.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+type BatchItemStatus string
+
+const (
+	BatchItemCreated  BatchItemStatus = "created"
+	BatchItemInvalid  BatchItemStatus = "invalid"
+	BatchItemConflict BatchItemStatus = "conflict"
+	// BatchItemAborted marks valid items of an atomic batch that failed.
+	BatchItemAborted BatchItemStatus = "aborted"
+)
+
+// ImportRowStatus is what an import does, or would do in a dry run, with a
+// row.
+type ImportRowStatus string
+
+const (
+	ImportCreated ImportRowStatus = "created"
+	// ImportUpdated marks rows whose email matches an existing user with
+	// different names.
+	ImportUpdated   ImportRowStatus = "updated"
+	ImportUnchanged ImportRowStatus = "unchanged"
+	ImportRejected  ImportRowStatus = "rejected"
+	// ImportFailed marks rows that were not imported because the import
+	// stopped on an error. They can be sent again.
+	ImportFailed ImportRowStatus = "failed"
 )
 
 type Service interface {
 	GetUsersMany(context.Context, GetUsersInput) (*GetUsersResponse, error)
+	// ExportUsers calls fn for every matching user, streaming them from
+	// storage instead of loading the whole list.
+	ExportUsers(context.Context, ExportUsersInput, func(User) error) error
 	GetUsersOne(context.Context, GetUserInput) (*GetUserResponse, error)
+	// BatchGetUsers returns the users of many ids and emails in one call.
+	BatchGetUsers(context.Context, BatchGetUsersInput) (*BatchGetUsersResponse, error)
 	CreateUser(context.Context, CreateUserInput) (*GetUserResponse, error)
+	CreateUsersBatch(context.Context, CreateUsersBatchInput) (*CreateUsersBatchResponse, error)
+	// ImportUsers creates the users of the rows, or updates the users they
+	// match by email. A dry run only reports what would be done. Rows are
+	// committed in batches: when a batch fails after others were committed,
+	// the report is returned with the rows of the failed batch and the ones
+	// after it marked ImportFailed.
+	ImportUsers(context.Context, ImportUsersInput) (*ImportUsersResponse, error)
 	UpdateUser(context.Context, UpdateUserInput) (*GetUserResponse, error)
+	// UpsertUser creates or updates the user holding an email in one step.
+	UpsertUser(context.Context, UpsertUserInput) (*UpsertUserResponse, error)
+	// PatchUser applies a patch to the current state of a user.
+	PatchUser(context.Context, PatchUserInput) (*GetUserResponse, error)
+	DeleteUser(context.Context, DeleteUserInput) error
+	RestoreUser(context.Context, RestoreUserInput) (*GetUserResponse, error)
+	GetUserHistory(context.Context, GetUserHistoryInput) (*GetUserHistoryResponse, error)
+}
+
+// SortOrder returns the sort of the input, or DefaultSort.
+func (v *GetUsersInput) SortOrder() SortSpec {
+	if v.Sort == nil {
+		return DefaultSort
+	}
+	return v.Sort
 }
 
 func (v *GetUsersInput) Validate() error {
+	var errs ValidationErrors
 	if v.Limit < 1 {
-		return fmt.Errorf("limit must be greater than 0")
+		errs.Add("limit", FieldOutOfRange, "limit must be greater than 0")
 	}
 	if v.Offset < 0 {
-		return fmt.Errorf("offset must be greater than or equal to 0")
-	}
-	if v.Query != nil && *v.Query == "" {
-		return fmt.Errorf("query cannot be an empty string")
+		errs.Add("offset", FieldOutOfRange, "offset must be greater than or equal to 0")
 	}
-	if v.OrderBy != nil && *v.OrderBy == "" {
-		return fmt.Errorf("order_by cannot be an empty string")
+	validateListQuery(&errs, v.Query, v.Sort)
+	validateFilters(&errs, v.Filters)
+	validateFields(&errs, v.Fields)
+	if v.Cursor != nil {
+		cursor, err := DecodeCursor(*v.Cursor)
+		if err != nil {
+			errs.Add("cursor", FieldInvalid, "%v", err)
+		} else if sort := v.SortOrder(); cursor.Sort != sort.String() || len(cursor.Keys) != len(sort) {
+			errs.Add("cursor", FieldInvalid, "cursor does not match sort")
+		}
+		if v.Offset != 0 {
+			errs.Add("offset", FieldInvalid, "offset cannot be combined with cursor")
+		}
 	}
-	return nil
+	return errs.Err()
 }
 
-func (v *GetUserInput) Validate() error {
-	if v.ID == nil {
-		return fmt.Errorf("id must be provided")
+// validateListQuery checks the query and sort of a users list.
+func validateListQuery(errs *ValidationErrors, query *string, sort SortSpec) {
+	if query != nil && *query == "" {
+		errs.Add("query", FieldInvalid, "query cannot be an empty string")
+	}
+	if sort != nil {
+		if err := sort.validate(); err != nil {
+			errs.Add("sort", FieldInvalid, "%v", err)
+		} else if sort.Has(RelevanceOrder) && query == nil {
+			errs.Add("sort", FieldInvalid, "sorting by relevance requires a query")
+		}
 	}
+}
 
-	if v.ID != nil {
-		if len(*v.ID) != 36 {
-			return fmt.Errorf("invalid id format, length must be 36 characters")
+// validateFilters checks the filters of a users list, reporting each under
+// its query parameter.
+func validateFilters(errs *ValidationErrors, filters []Filter) {
+	for _, filter := range filters {
+		if err := filter.validate(); err != nil {
+			errs.Add(filter.String(), FieldInvalid, "%v", err)
 		}
+	}
+}
 
-		_, err := uuid.Parse(*v.ID)
-		if err != nil {
-			return fmt.Errorf("invalid id format: %v", err)
+// validateFields checks a sparse fieldset against UserFields.
+func validateFields(errs *ValidationErrors, fields []string) {
+	if fields == nil {
+		return
+	}
+	if len(fields) == 0 {
+		errs.Add("fields", FieldInvalid, "fields cannot be empty")
+		return
+	}
+	for _, field := range fields {
+		if !slices.Contains(UserFields, field) {
+			errs.Add("fields", FieldInvalid, "unknown field %q, fields must be among: %s", field, strings.Join(UserFields, ", "))
 		}
 	}
+}
+
+func validateID(errs *ValidationErrors, field, id string) {
+	if len(id) != 36 {
+		errs.Add(field, FieldInvalid, "invalid id format, length must be 36 characters")
+		return
+	}
 
-	return nil
+	if _, err := uuid.Parse(id); err != nil {
+		errs.Add(field, FieldInvalid, "invalid id format: %v", err)
+	}
 }
 
-func isValidEmail(email string) bool {
-	re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return re.MatchString(email)
+func (v *ExportUsersInput) Validate() error {
+	var errs ValidationErrors
+	validateListQuery(&errs, v.Query, v.Sort)
+	validateFilters(&errs, v.Filters)
+	validateFields(&errs, v.Fields)
+	return errs.Err()
 }
 
-func (v *CreateUserInput) Validate() error {
-	if v.Email == "" {
-		return fmt.Errorf("email is required")
+// Validate checks that the input has exactly one lookup key and brings its
+// email to its canonical form.
+func (v *GetUserInput) Validate() error {
+	var errs ValidationErrors
+	keys := 0
+	if v.ID != nil {
+		keys++
+		validateID(&errs, "id", *v.ID)
+	}
+	if v.Email != nil {
+		keys++
+		email := CanonicalEmail(normalizeText(*v.Email))
+		v.Email = &email
+		validateEmail(&errs, "email", email)
+	}
+	if v.ExternalSource != nil || v.ExternalID != nil {
+		keys++
+		if v.ExternalSource == nil || v.ExternalID == nil {
+			errs.Add("external_id", FieldRequired, "external_source and external_id must be provided together")
+		} else {
+			validateExternalID(&errs, v.ExternalSource, v.ExternalID)
+			if *v.ExternalSource == "" && *v.ExternalID == "" {
+				errs.Add("external_id", FieldRequired, "external_source and external_id are required")
+			}
+		}
 	}
-	if !isValidEmail(v.Email) {
-		return fmt.Errorf("invalid email format")
+	switch {
+	case keys == 0:
+		errs.Add("", FieldRequired, "one of id, email or external_source and external_id must be provided")
+	case keys > 1:
+		errs.Add("", FieldInvalid, "only one of id, email or external_source and external_id can be provided")
 	}
+	validateFields(&errs, v.Fields)
+	return errs.Err()
+}
 
-	if v.FirstName == "" {
-		return fmt.Errorf("first_name is required")
+// Validate checks the keys of the input and brings its emails to their
+// canonical form.
+func (v *BatchGetUsersInput) Validate() error {
+	var errs ValidationErrors
+	if len(v.IDs) == 0 && len(v.Emails) == 0 {
+		errs.Add("", FieldRequired, "at least one id or email is required")
+	}
+	for i, id := range v.IDs {
+		validateID(&errs, fmt.Sprintf("ids/%d", i), id)
+	}
+	for i := range v.Emails {
+		v.Emails[i] = CanonicalEmail(normalizeText(v.Emails[i]))
+		validateEmail(&errs, fmt.Sprintf("emails/%d", i), v.Emails[i])
 	}
+	validateFields(&errs, v.Fields)
+	return errs.Err()
+}
+
+func (v *CreateUserInput) Validate() error {
+	var errs ValidationErrors
+	validateUserFields(&errs, &v.Email, &v.FirstName, &v.LastName)
+	validateExternalID(&errs, &v.ExternalSource, &v.ExternalID)
+	return errs.Err()
+}
 
-	if v.LastName == "" {
-		return fmt.Errorf("last_name is required")
+func (v *CreateUsersBatchInput) Validate() error {
+	var errs ValidationErrors
+	if len(v.Users) == 0 {
+		errs.Add("users", FieldRequired, "at least one user is required")
+	}
+	if v.Mode != BatchAtomic && v.Mode != BatchBestEffort {
+		errs.Add("mode", FieldInvalid, "mode must be %s or %s", BatchAtomic, BatchBestEffort)
 	}
+	return errs.Err()
+}
 
-	return nil
+func (v *ImportUsersInput) Validate() error {
+	var errs ValidationErrors
+	if len(v.Rows) == 0 {
+		errs.Add("rows", FieldRequired, "at least one row is required")
+	}
+	return errs.Err()
 }
 
 func (v *UpdateUserInput) Validate() error {
-	return nil
+	var errs ValidationErrors
+	validateID(&errs, "id", v.ID)
+	validateUserFields(&errs, &v.Email, &v.FirstName, &v.LastName)
+	validateExternalID(&errs, &v.ExternalSource, &v.ExternalID)
+	return errs.Err()
+}
+
+func (v *UpsertUserInput) Validate() error {
+	var errs ValidationErrors
+	validateUserFields(&errs, &v.Email, &v.FirstName, &v.LastName)
+	validateExternalID(&errs, &v.ExternalSource, &v.ExternalID)
+	return errs.Err()
+}
+
+func (v *PatchUserInput) Validate() error {
+	var errs ValidationErrors
+	validateID(&errs, "id", v.ID)
+	if v.Patch == nil {
+		errs.Add("", FieldRequired, "a patch document is required")
+	}
+	return errs.Err()
+}
+
+func (v *DeleteUserInput) Validate() error {
+	var errs ValidationErrors
+	validateID(&errs, "id", v.ID)
+	return errs.Err()
+}
+
+func (v *RestoreUserInput) Validate() error {
+	var errs ValidationErrors
+	validateID(&errs, "id", v.ID)
+	return errs.Err()
+}
+
+func (v *GetUserHistoryInput) Validate() error {
+	var errs ValidationErrors
+	if v.Limit < 1 {
+		errs.Add("limit", FieldOutOfRange, "limit must be greater than 0")
+	}
+	if v.Offset < 0 {
+		errs.Add("offset", FieldOutOfRange, "offset must be greater than or equal to 0")
+	}
+	validateID(&errs, "id", v.ID)
+	return errs.Err()
 }
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// UserSortFields is the allowlist of the fields the users list can be
+// sorted by: every field of User.
+var UserSortFields = UserFields
+
+// RelevanceOrder sorts search results by how well they match the query. It
+// can only be used with a query.
+const RelevanceOrder = "relevance"
+
+// SortKey is one field of a sort spec.
+type SortKey struct {
+	Field string
+	Desc  bool
+	// NullsFirst puts users without a value for the field before the
+	// others. By default they come last, whatever the direction.
+	NullsFirst bool
+}
+
+// SortSpec orders a users list by its keys in turn. The id of the users is
+// the implied final key, so that the order is total and pagination is
+// deterministic.
+type SortSpec []SortKey
+
+// DefaultSort is used when no sort is requested.
+var DefaultSort = SortSpec{{Field: "created_at"}}
+
+// Null placement suffixes of sort fields.
+const (
+	nullsFirstSuffix = ":nulls_first"
+	nullsLastSuffix  = ":nulls_last"
+)
+
+// ParseSort parses sort values such as "-created_at,last_name". A leading
+// "-" sorts a field in descending order, and a ":nulls_first" or
+// ":nulls_last" suffix places the users without a value.
+func ParseSort(s string) (SortSpec, error) {
+	if s == "" {
+		return nil, fmt.Errorf("sort cannot be an empty string")
+	}
+
+	spec := SortSpec{}
+	for _, part := range strings.Split(s, ",") {
+		var key SortKey
+		part = strings.TrimSpace(part)
+		if field, ok := strings.CutPrefix(part, "-"); ok {
+			key.Desc = true
+			part = field
+		}
+		if field, ok := strings.CutSuffix(part, nullsFirstSuffix); ok {
+			key.NullsFirst = true
+			part = field
+		} else if field, ok := strings.CutSuffix(part, nullsLastSuffix); ok {
+			part = field
+		}
+		key.Field = part
+		spec = append(spec, key)
+	}
+
+	return spec, spec.validate()
+}
+
+// ParseOrderBy parses the order_by values of the form "field" or
+// "field:asc|desc" that predate sort. A bare "relevance" sorts the best
+// matches first.
+func ParseOrderBy(s string) (SortSpec, error) {
+	field, direction, _ := strings.Cut(s, ":")
+	key := SortKey{Field: field}
+	switch strings.ToLower(direction) {
+	case "":
+		key.Desc = field == RelevanceOrder
+	case "asc":
+	case "desc":
+		key.Desc = true
+	default:
+		return nil, fmt.Errorf("order_by direction must be asc or desc")
+	}
+
+	spec := SortSpec{key}
+	return spec, spec.validate()
+}
+
+// validate checks the fields of the spec against the allowlist.
+func (s SortSpec) validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("sort must have at least one field")
+	}
+	seen := map[string]bool{}
+	for _, key := range s {
+		if key.Field != RelevanceOrder && !slices.Contains(UserSortFields, key.Field) {
+			return fmt.Errorf("unknown sort field %q, must be one of: %s, %s", key.Field, strings.Join(UserSortFields, ", "), RelevanceOrder)
+		}
+		if seen[key.Field] {
+			return fmt.Errorf("sort field %s is given more than once", key.Field)
+		}
+		seen[key.Field] = true
+	}
+	return nil
+}
+
+// Has reports whether the spec sorts by field.
+func (s SortSpec) Has(field string) bool {
+	return slices.ContainsFunc(s, func(key SortKey) bool { return key.Field == field })
+}
+
+// Tiebreak returns the id key that follows the keys of the spec, in the
+// direction of the last one, or false if the spec already ends its order on
+// id.
+func (s SortSpec) Tiebreak() (SortKey, bool) {
+	if s.Has("id") {
+		return SortKey{}, false
+	}
+	return SortKey{Field: "id", Desc: s[len(s)-1].Desc}, true
+}
+
+// Reverse returns the spec sorting the other way round, nulls included.
+func (s SortSpec) Reverse() SortSpec {
+	reversed := make(SortSpec, len(s))
+	for i, key := range s {
+		reversed[i] = SortKey{Field: key.Field, Desc: !key.Desc, NullsFirst: !key.NullsFirst}
+	}
+	return reversed
+}
+
+// String returns the spec in the syntax of ParseSort, with null placement
+// spelled out only where it isn't the default.
+func (s SortSpec) String() string {
+	parts := make([]string, len(s))
+	for i, key := range s {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + parts[i]
+		}
+		if key.NullsFirst {
+			parts[i] += nullsFirstSuffix
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -1,16 +1,113 @@
 package domain
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version is incremented on every change and serves as the user's ETag.
+	Version int64 `json:"version"`
+	// ExternalSource and ExternalID identify the user in another system,
+	// such as SSO or billing. They are set together, and an ID is unique
+	// within its source.
+	ExternalSource string `json:"external_source,omitempty"`
+	ExternalID     string `json:"external_id,omitempty"`
+}
+
+// UserFields lists the JSON names of the fields of User, in order.
+var UserFields = jsonFieldNames(reflect.TypeFor[User]())
+
+func jsonFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Field returns the value of the field with the given JSON name, or nil for
+// an unknown name or an unset optional field.
+func (u User) Field(name string) any {
+	switch name {
+	case "id":
+		return u.ID
+	case "email":
+		return u.Email
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "created_at":
+		return u.CreatedAt
+	case "updated_at":
+		return u.UpdatedAt
+	case "deleted_at":
+		if u.DeletedAt == nil {
+			return nil
+		}
+		return *u.DeletedAt
+	case "version":
+		return u.Version
+	case "external_source":
+		return optionalField(u.ExternalSource)
+	case "external_id":
+		return optionalField(u.ExternalID)
+	}
+	return nil
+}
+
+func optionalField(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// PartialUser is the JSON representation of a user restricted to a sparse
+// fieldset. Fields are written in the order of UserFields, and unset
+// optional fields are left out as in the full representation.
+type PartialUser struct {
+	User   User
+	Fields []string
+}
+
+func (p PartialUser) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, name := range UserFields {
+		value := p.User.Field(name)
+		if value == nil || !slices.Contains(p.Fields, name) {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(encoded)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+type auditMemoryRepository struct {
+	store *MemoryStore
+}
+
+func NewAuditMemoryRepository(store *MemoryStore) *auditMemoryRepository {
+	return &auditMemoryRepository{
+		store: store,
+	}
+}
+
+func (a *auditMemoryRepository) InsertAuditRecord(ctx context.Context, record *domain.AuditRecord) error {
+	defer a.store.lock(ctx)()
+
+	record.ID = int64(len(a.store.audit)) + 1
+	a.store.audit = append(a.store.audit, *record)
+
+	return nil
+}
+
+func (a *auditMemoryRepository) InsertAuditRecords(ctx context.Context, records []domain.AuditRecord) error {
+	defer a.store.lock(ctx)()
+
+	for _, record := range records {
+		record.ID = int64(len(a.store.audit)) + 1
+		a.store.audit = append(a.store.audit, record)
+	}
+
+	return nil
+}
+
+func (a *auditMemoryRepository) GetAuditRecords(ctx context.Context, userID uuid.UUID, limit, offset int) (*domain.List[domain.AuditRecord], error) {
+	defer a.store.rlock(ctx)()
+
+	list := domain.List[domain.AuditRecord]{Elements: []domain.AuditRecord{}}
+	for i := len(a.store.audit) - 1; i >= 0; i-- {
+		record := a.store.audit[i]
+		if record.UserID != userID {
+			continue
+		}
+		if list.Total >= int64(offset) && len(list.Elements) < limit {
+			list.Elements = append(list.Elements, record)
+		}
+		list.Total++
+	}
+
+	return &list, nil
+}
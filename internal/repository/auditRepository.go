@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+type AuditRepository interface {
+	InsertAuditRecord(ctx context.Context, record *domain.AuditRecord) error
+	InsertAuditRecords(ctx context.Context, records []domain.AuditRecord) error
+	// GetAuditRecords returns the records of a user, newest first.
+	GetAuditRecords(ctx context.Context, userID uuid.UUID, limit, offset int) (*domain.List[domain.AuditRecord], error)
+}
+
+type auditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *auditRepository {
+	return &auditRepository{
+		db: db,
+	}
+}
+
+func (a *auditRepository) conn(ctx context.Context) dbExecutor {
+	return executor(ctx, a.db)
+}
+
+func (a *auditRepository) InsertAuditRecord(ctx context.Context, record *domain.AuditRecord) error {
+	changes, err := json.Marshal(record.Changes)
+	if err != nil {
+		return fmt.Errorf("error encoding audit changes: %w", err)
+	}
+
+	query := `INSERT INTO user_audit (user_id, actor, operation, changes, created_at)
+			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = a.conn(ctx).QueryRowContext(ctx, query, record.UserID, record.Actor, record.Operation, changes, record.CreatedAt).Scan(&record.ID)
+	if err != nil {
+		return dbError("error creating audit record", err)
+	}
+
+	return nil
+}
+
+func (a *auditRepository) InsertAuditRecords(ctx context.Context, records []domain.AuditRecord) error {
+	for start := 0; start < len(records); start += insertUsersChunk {
+		chunk := records[start:min(start+insertUsersChunk, len(records))]
+
+		values := make([]string, 0, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*5)
+		for _, record := range chunk {
+			changes, err := json.Marshal(record.Changes)
+			if err != nil {
+				return fmt.Errorf("error encoding audit changes: %w", err)
+			}
+
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+			args = append(args, record.UserID, record.Actor, record.Operation, changes, record.CreatedAt)
+		}
+
+		query := "INSERT INTO user_audit (user_id, actor, operation, changes, created_at) VALUES " + strings.Join(values, ", ")
+		if _, err := a.conn(ctx).ExecContext(ctx, query, args...); err != nil {
+			return dbError("error creating audit records", err)
+		}
+	}
+
+	return nil
+}
+
+func (a *auditRepository) GetAuditRecords(ctx context.Context, userID uuid.UUID, limit, offset int) (*domain.List[domain.AuditRecord], error) {
+	list := domain.List[domain.AuditRecord]{Elements: []domain.AuditRecord{}}
+
+	err := a.conn(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM user_audit WHERE user_id = $1", userID).Scan(&list.Total)
+	if err != nil {
+		return nil, dbError("error occured while counting rows in 'user_audit'", err)
+	}
+
+	query := `SELECT id, user_id, actor, operation, changes, created_at FROM user_audit
+			  WHERE user_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3`
+	rows, err := a.conn(ctx).QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, dbError("error occured while querying rows in 'user_audit'", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record domain.AuditRecord
+		var changes []byte
+		if err := rows.Scan(&record.ID, &record.UserID, &record.Actor, &record.Operation, &changes, &record.CreatedAt); err != nil {
+			return nil, dbError("error occured while scanning rows in 'user_audit'", err)
+		}
+		if err := json.Unmarshal(changes, &record.Changes); err != nil {
+			return nil, fmt.Errorf("error decoding audit changes: %w", err)
+		}
+		list.Elements = append(list.Elements, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("error occured while reading rows in 'user_audit'", err)
+	}
+
+	return &list, nil
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/lib/pq"
+)
+
+// dbError wraps an error returned by the database. Errors caused by losing
+// or failing to reach the database are marked as domain.ErrUnavailable.
+func dbError(message string, err error) error {
+	if isUnavailable(err) {
+		return domain.Unavailable(message, err)
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+func isUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"53", // insufficient resources
+			"57": // operator intervention, such as a shutdown
+			return pqErr.Code != "57014" // query_canceled
+		}
+	}
+	return false
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// userConflict returns the domain error of a unique violation on users,
+// telling the external id index apart from the email one, or nil for other
+// errors.
+func userConflict(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+	if pqErr.Constraint == "users_external_id_key" {
+		return domain.ExternalIDInUse("external id already in use")
+	}
+	return domain.EmailInUse("email already in use")
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+func TestParseFilter(t *testing.T) {
+	id1 := uuid.MustParse("00000000-0000-4000-8000-000000000001")
+	id2 := uuid.MustParse("00000000-0000-4000-8000-000000000002")
+	tooManyIDs := strings.TrimSuffix(strings.Repeat(id1.String()+",", 101), ",")
+
+	tests := []struct {
+		field, op, value string
+		want             any
+		wantOp           domain.FilterOp
+		wantErr          bool
+	}{
+		{field: "id", op: "in", value: id1.String() + ", " + id2.String(), want: []uuid.UUID{id1, id2}, wantOp: domain.FilterIn},
+		{field: "id", op: "not_in", value: id1.String(), want: []uuid.UUID{id1}, wantOp: domain.FilterNotIn},
+		{field: "id", op: "in", value: "42", wantErr: true},
+		{field: "id", op: "in", value: tooManyIDs, wantErr: true},
+		{field: "created_at", op: "gte", value: "2024-01-01", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), wantOp: domain.FilterGte},
+		{field: "updated_at", op: "lt", value: "2024-01-01T10:00:00+05:00", want: time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC), wantOp: domain.FilterLt},
+		{field: "created_at", op: "gt", value: "yesterday", wantErr: true},
+		{field: "email_domain", value: "Bücher.Example", want: "xn--bcher-kva.example", wantOp: domain.FilterEq},
+		{field: "first_name", value: " John ", want: "John", wantOp: domain.FilterEq},
+		{field: "last_name", op: "prefix", value: "Sm", want: "Sm", wantOp: domain.FilterPrefix},
+		{field: "last_name", op: "prefix", value: " ", wantErr: true},
+		{field: "password", value: "secret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"["+tt.op+"]="+tt.value[:min(len(tt.value), 40)], func(t *testing.T) {
+			got, err := domain.ParseFilter(tt.field, tt.op, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseFilter() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if got.Field != tt.field || got.Op != tt.wantOp {
+				t.Errorf("ParseFilter() = %s, want %s[%s]", got, tt.field, tt.wantOp)
+			}
+			if fmt.Sprint(got.Value) != fmt.Sprint(tt.want) {
+				t.Errorf("ParseFilter() value = %v, want %v", got.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterOperatorAllowlist(t *testing.T) {
+	allowed := map[string][]domain.FilterOp{
+		"id":           {domain.FilterIn, domain.FilterNotIn},
+		"email_domain": {domain.FilterEq},
+		"first_name":   {domain.FilterEq, domain.FilterPrefix},
+		"last_name":    {domain.FilterEq, domain.FilterPrefix},
+		"created_at":   {domain.FilterGt, domain.FilterGte, domain.FilterLt, domain.FilterLte},
+		"updated_at":   {domain.FilterGt, domain.FilterGte, domain.FilterLt, domain.FilterLte},
+		"email":        nil,
+		"deleted_at":   nil,
+		"version":      nil,
+	}
+	values := map[string]string{
+		"id":         "00000000-0000-4000-8000-000000000001",
+		"created_at": "2024-01-01",
+		"updated_at": "2024-01-01",
+		"deleted_at": "2024-01-01",
+		"version":    "1",
+	}
+	ops := []domain.FilterOp{
+		domain.FilterEq, domain.FilterGt, domain.FilterGte, domain.FilterLt, domain.FilterLte,
+		domain.FilterPrefix, domain.FilterIn, domain.FilterNotIn, "ne",
+	}
+
+	for field, fieldOps := range allowed {
+		value, ok := values[field]
+		if !ok {
+			value = "example.com"
+		}
+		for _, op := range ops {
+			_, err := domain.ParseFilter(field, string(op), value)
+			if want := slices.Contains(fieldOps, op); (err == nil) != want {
+				t.Errorf("ParseFilter(%s[%s]) error = %v, want allowed = %v", field, op, err, want)
+			}
+		}
+	}
+}
+
+// pgWallClock renders t the way Postgres keeps it in a timestamp without
+// time zone column: its wall clock, with the offset dropped.
+func pgWallClock(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05.000000000")
+}
+
+// pgCompare evaluates a timestamp comparison the way Postgres does on the
+// wall clocks of the stored and the bound time.
+func pgCompare(stored time.Time, operator string, bound time.Time) bool {
+	c := strings.Compare(pgWallClock(stored), pgWallClock(bound))
+	switch operator {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return c == 0
+}
+
+// TestFilterCompileMatchesParity checks that the SQL of a filter selects
+// the users that Filter.Matches selects on the memory backend, with the
+// server in a zone other than UTC.
+func TestFilterCompileMatchesParity(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	defer func() { time.Local = local }()
+
+	// Users are written with the local time.Now(), as the service does.
+	users := []domain.User{
+		{ID: uuid.MustParse("00000000-0000-4000-8000-000000000001"), Email: "john@example.com", FirstName: "John", LastName: "Smith",
+			CreatedAt: time.Date(2024, 1, 1, 3, 0, 0, 0, time.Local), UpdatedAt: time.Date(2024, 1, 1, 3, 0, 0, 0, time.Local)},
+		{ID: uuid.MustParse("00000000-0000-4000-8000-000000000002"), Email: "alice@corp.example", FirstName: "Alice", LastName: "Smyth",
+			CreatedAt: time.Date(2024, 1, 1, 5, 0, 0, 0, time.Local), UpdatedAt: time.Date(2024, 1, 2, 5, 0, 0, 0, time.Local)},
+		{ID: uuid.MustParse("00000000-0000-4000-8000-000000000003"), Email: "bob@example.com", FirstName: "Bob", LastName: "Brown",
+			CreatedAt: time.Date(2024, 1, 1, 7, 0, 0, 0, time.Local), UpdatedAt: time.Date(2024, 1, 1, 7, 0, 0, 0, time.Local)},
+	}
+
+	tests := []struct {
+		field, op, value string
+		want             string
+		wantIDs          []int
+	}{
+		{field: "created_at", op: "gte", value: "2024-01-01", want: "created_at >= $1", wantIDs: []int{1, 2}},
+		{field: "created_at", op: "gt", value: "2024-01-01T00:00:00Z", want: "created_at > $1", wantIDs: []int{2}},
+		{field: "created_at", op: "lt", value: "2024-01-01T02:00:00Z", want: "created_at < $1", wantIDs: []int{0, 1}},
+		{field: "created_at", op: "lte", value: "2024-01-01T05:00:00+05:00", want: "created_at <= $1", wantIDs: []int{0, 1}},
+		{field: "updated_at", op: "gte", value: "2024-01-01T12:00:00+05:00", want: "updated_at >= $1", wantIDs: []int{1}},
+		{field: "first_name", value: "John", want: "first_name = $1", wantIDs: []int{0}},
+		{field: "last_name", op: "prefix", value: "SM", want: "last_name ILIKE $1", wantIDs: []int{0, 1}},
+		{field: "email_domain", value: "corp.example", want: "split_part(email, '@', 2) = $1", wantIDs: []int{1}},
+		{field: "id", op: "in", value: "00000000-0000-4000-8000-000000000003", want: "id = ANY($1::uuid[])", wantIDs: []int{2}},
+		{field: "id", op: "not_in", value: "00000000-0000-4000-8000-000000000003", want: "NOT id = ANY($1::uuid[])", wantIDs: []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"["+tt.op+"]="+tt.value, func(t *testing.T) {
+			filter, err := domain.ParseFilter(tt.field, tt.op, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var matched []int
+			for i, user := range users {
+				if filter.Matches(user) {
+					matched = append(matched, i)
+				}
+			}
+			if !slices.Equal(matched, tt.wantIDs) {
+				t.Errorf("Matches() selects users %v, want %v", matched, tt.wantIDs)
+			}
+
+			f := &usersFilter{}
+			if got := f.compile(filter); got != tt.want {
+				t.Fatalf("compile() = %q, want %q", got, tt.want)
+			}
+			if len(f.args) != 1 {
+				t.Fatalf("compile() args = %v, want one", f.args)
+			}
+			// Only timestamps compare differently in SQL and in Go.
+			bound, ok := f.args[0].(time.Time)
+			if !ok {
+				return
+			}
+
+			var selected []int
+			operator := strings.Fields(tt.want)[1]
+			for i, user := range users {
+				stored := user.CreatedAt
+				if tt.field == "updated_at" {
+					stored = user.UpdatedAt
+				}
+				if pgCompare(stored, operator, bound) {
+					selected = append(selected, i)
+				}
+			}
+			if !slices.Equal(selected, matched) {
+				t.Errorf("SQL selects users %v, Matches() %v", selected, matched)
+			}
+		})
+	}
+}
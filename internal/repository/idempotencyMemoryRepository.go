@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+type idempotencyMemoryRepository struct {
+	mu        sync.Mutex
+	responses map[string]domain.IdempotentResponse
+}
+
+func NewIdempotencyMemoryRepository() *idempotencyMemoryRepository {
+	return &idempotencyMemoryRepository{
+		responses: map[string]domain.IdempotentResponse{},
+	}
+}
+
+func (i *idempotencyMemoryRepository) Begin(ctx context.Context, key, fingerprint string, leaseUntil time.Time) (*domain.IdempotentResponse, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if response, ok := i.responses[key]; ok && response.ExpiresAt.After(time.Now()) {
+		return &response, nil
+	}
+	i.responses[key] = domain.IdempotentResponse{
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   leaseUntil,
+	}
+	return nil, nil
+}
+
+func (i *idempotencyMemoryRepository) Extend(ctx context.Context, key, fingerprint string, leaseUntil time.Time) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	claimed, ok := i.responses[key]
+	if !ok || claimed.Completed || claimed.Fingerprint != fingerprint {
+		return nil
+	}
+	claimed.ExpiresAt = leaseUntil
+	i.responses[key] = claimed
+	return nil
+}
+
+func (i *idempotencyMemoryRepository) Complete(ctx context.Context, response domain.IdempotentResponse) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	claimed, ok := i.responses[response.Key]
+	if !ok || claimed.Completed || claimed.Fingerprint != response.Fingerprint {
+		return nil
+	}
+	response.Completed = true
+	i.responses[response.Key] = response
+	return nil
+}
+
+func (i *idempotencyMemoryRepository) Release(ctx context.Context, key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if response, ok := i.responses[key]; ok && !response.Completed {
+		delete(i.responses, key)
+	}
+	return nil
+}
+
+func (i *idempotencyMemoryRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for key, response := range i.responses {
+		if !response.ExpiresAt.After(now) {
+			delete(i.responses, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+type idempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) *idempotencyRepository {
+	return &idempotencyRepository{
+		db: db,
+	}
+}
+
+func (i *idempotencyRepository) Begin(ctx context.Context, key, fingerprint string, leaseUntil time.Time) (*domain.IdempotentResponse, error) {
+	// An expired key, or a claim whose lease ran out, is taken over as if
+	// it had never been used.
+	claim := `INSERT INTO idempotency_keys (key, fingerprint, expires_at) VALUES ($1, $2, $3)
+			  ON CONFLICT (key) DO UPDATE
+			  SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+			  WHERE idempotency_keys.expires_at <= now()
+			  RETURNING key`
+	lookup := `SELECT fingerprint, status, headers, body, expires_at FROM idempotency_keys
+			   WHERE key = $1 AND expires_at > now()`
+
+	// The holder of the key can release it or let it expire between the two
+	// queries, in which case the claim is tried again.
+	for {
+		err := i.db.QueryRowContext(ctx, claim, key, fingerprint, leaseUntil).Scan(&key)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, dbError("error claiming idempotency key", err)
+		}
+
+		response := domain.IdempotentResponse{Key: key}
+		var status sql.NullInt64
+		var headers []byte
+		err = i.db.QueryRowContext(ctx, lookup, key).Scan(&response.Fingerprint, &status, &headers, &response.Body, &response.ExpiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, dbError("error getting idempotency key", err)
+		}
+
+		if status.Valid {
+			response.Completed = true
+			response.Status = int(status.Int64)
+			if err := json.Unmarshal(headers, &response.Header); err != nil {
+				return nil, fmt.Errorf("error decoding idempotent response headers: %w", err)
+			}
+		}
+		return &response, nil
+	}
+}
+
+func (i *idempotencyRepository) Extend(ctx context.Context, key, fingerprint string, leaseUntil time.Time) error {
+	query := "UPDATE idempotency_keys SET expires_at = $3 WHERE key = $1 AND fingerprint = $2 AND status IS NULL"
+	if _, err := i.db.ExecContext(ctx, query, key, fingerprint, leaseUntil); err != nil {
+		return dbError("error extending idempotency key", err)
+	}
+	return nil
+}
+
+func (i *idempotencyRepository) Complete(ctx context.Context, response domain.IdempotentResponse) error {
+	headers, err := json.Marshal(response.Header)
+	if err != nil {
+		return fmt.Errorf("error encoding idempotent response headers: %w", err)
+	}
+
+	query := `UPDATE idempotency_keys SET status = $3, headers = $4, body = $5, expires_at = $6
+			  WHERE key = $1 AND fingerprint = $2 AND status IS NULL`
+	if _, err := i.db.ExecContext(ctx, query, response.Key, response.Fingerprint, response.Status, headers, response.Body, response.ExpiresAt); err != nil {
+		return dbError("error storing idempotent response", err)
+	}
+	return nil
+}
+
+func (i *idempotencyRepository) Release(ctx context.Context, key string) error {
+	if _, err := i.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL", key); err != nil {
+		return dbError("error releasing idempotency key", err)
+	}
+	return nil
+}
+
+func (i *idempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := i.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		return 0, dbError("error deleting expired idempotency keys", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps the data of the in-memory repositories. It is safe for
+// concurrent use.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]domain.User
+	audit []domain.AuditRecord
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: map[uuid.UUID]domain.User{},
+	}
+}
+
+type memoryTxKey struct{}
+
+// inTx reports whether ctx carries a transaction on s, whose lock is then
+// already held.
+func (s *MemoryStore) inTx(ctx context.Context) bool {
+	return ctx.Value(memoryTxKey{}) == s
+}
+
+// lock write-locks the store unless ctx is in one of its transactions, and
+// returns the matching unlock.
+func (s *MemoryStore) lock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// rlock is the read-only counterpart of lock.
+func (s *MemoryStore) rlock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+type memoryTxManager struct {
+	store *MemoryStore
+}
+
+// NewMemoryTxManager returns a TxManager for the repositories backed by
+// store. Transactions are serialized and undone by restoring a snapshot.
+func NewMemoryTxManager(store *MemoryStore) *memoryTxManager {
+	return &memoryTxManager{
+		store: store,
+	}
+}
+
+func (m *memoryTxManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.store.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	users, audit := maps.Clone(m.store.users), m.store.audit
+	committed := false
+	defer func() {
+		if !committed {
+			m.store.users, m.store.audit = users, audit
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, m.store)); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// LoadUsersFixture seeds the store with the JSON array of users in path.
+// Their writable fields are validated and normalized as on create, and
+// missing IDs and timestamps are filled in.
+func (s *MemoryStore) LoadUsersFixture(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading users fixture: %v", err)
+	}
+
+	var users []domain.User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("error parsing users fixture: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i, user := range users {
+		input := domain.CreateUserInput{
+			Email:          user.Email,
+			FirstName:      user.FirstName,
+			LastName:       user.LastName,
+			ExternalSource: user.ExternalSource,
+			ExternalID:     user.ExternalID,
+		}
+		if err := input.Validate(); err != nil {
+			return fmt.Errorf("error seeding users fixture: user %d: %v", i, err)
+		}
+		user.Email, user.FirstName, user.LastName = input.Email, input.FirstName, input.LastName
+		user.ExternalSource, user.ExternalID = input.ExternalSource, input.ExternalID
+
+		if user.ID == uuid.Nil {
+			user.ID = uuid.New()
+		}
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = user.CreatedAt
+		}
+		if user.Version == 0 {
+			user.Version = 1
+		}
+		if _, ok := s.users[user.ID]; ok {
+			return fmt.Errorf("error seeding users fixture: duplicate id %s", user.ID)
+		}
+		if s.userByEmail(user.Email) != nil {
+			return fmt.Errorf("error seeding users fixture: duplicate email %s", user.Email)
+		}
+		if s.userByExternalID(user.ExternalSource, user.ExternalID) != nil {
+			return fmt.Errorf("error seeding users fixture: duplicate external id %s/%s", user.ExternalSource, user.ExternalID)
+		}
+		s.users[user.ID] = user
+	}
+
+	return nil
+}
+
+// userByEmail returns the user holding email, deleted or not, ignoring case. The caller
+// must hold mu.
+func (s *MemoryStore) userByEmail(email string) *domain.User {
+	for _, user := range s.users {
+		if domain.EmailKey(user.Email) == domain.EmailKey(email) {
+			return &user
+		}
+	}
+	return nil
+}
+
+// userByExternalID returns the user holding the external id of source, or
+// nil. Users without an external id never conflict. The caller must hold mu.
+func (s *MemoryStore) userByExternalID(source, id string) *domain.User {
+	if id == "" {
+		return nil
+	}
+	for _, user := range s.users {
+		if user.ExternalSource == source && user.ExternalID == id {
+			return &user
+		}
+	}
+	return nil
+}
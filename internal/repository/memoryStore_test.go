@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadUsersFixture(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		wantEmail string
+		wantErr   string
+	}{
+		{
+			name:      "normalized as on create",
+			fixture:   `[{"email": " Ann@Bücher.EXAMPLE ", "first_name": " Ann ", "last_name": "Lee"}]`,
+			wantEmail: "Ann@xn--bcher-kva.example",
+		},
+		{
+			name:    "invalid email",
+			fixture: `[{"email": "not-an-email", "first_name": "Ann", "last_name": "Lee"}]`,
+			wantErr: "user 0: invalid email format",
+		},
+		{
+			name:    "missing name",
+			fixture: `[{"email": "ann@example.com", "last_name": "Lee"}]`,
+			wantErr: "user 0:",
+		},
+		{
+			name:    "external id without a source",
+			fixture: `[{"email": "ann@example.com", "first_name": "Ann", "last_name": "Lee", "external_id": "00u1"}]`,
+			wantErr: "user 0:",
+		},
+		{
+			name: "emails differing in case only",
+			fixture: `[{"email": "ann@example.com", "first_name": "Ann", "last_name": "Lee"},
+				{"email": "ANN@EXAMPLE.COM", "first_name": "Ann", "last_name": "Lee"}]`,
+			wantErr: "duplicate email",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.json")
+			if err := os.WriteFile(path, []byte(tt.fixture), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			store := NewMemoryStore()
+			err := store.LoadUsersFixture(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadUsersFixture() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadUsersFixture() error = %v", err)
+			}
+			for _, user := range store.users {
+				if user.Email != tt.wantEmail || user.FirstName != "Ann" {
+					t.Errorf("seeded user = %q %q, want %q %q", user.Email, user.FirstName, tt.wantEmail, "Ann")
+				}
+			}
+		})
+	}
+}
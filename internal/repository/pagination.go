@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"slices"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+// keysetPage turns a page fetched with one extra row into a list with its
+// next/prev cursors. keys holds the sort keys of each fetched row. Rows of a
+// backward page are expected in fetch order and are reversed here.
+func keysetPage(users []domain.User, keys [][]*string, input domain.GetUsersInput, sort domain.SortSpec, cursor *domain.Cursor) domain.List[domain.User] {
+	hasMore := len(users) > input.Limit
+	if hasMore {
+		users, keys = users[:input.Limit], keys[:input.Limit]
+	}
+
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		slices.Reverse(users)
+		slices.Reverse(keys)
+	}
+
+	list := domain.List[domain.User]{Elements: users}
+	if len(users) == 0 {
+		return list
+	}
+
+	last, first := len(users)-1, 0
+	if hasMore || backward {
+		list.NextCursor = domain.Cursor{Sort: sort.String(), Keys: keys[last], ID: users[last].ID}.Encode()
+	}
+	if (backward && hasMore) || (!backward && (cursor != nil || input.Offset > 0)) {
+		list.PrevCursor = domain.Cursor{Sort: sort.String(), Keys: keys[first], ID: users[first].ID, Backward: true}.Encode()
+	}
+
+	return list
+}
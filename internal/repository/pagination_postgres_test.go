@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/ExonegeS/REST-API-001/internal/adapter/postgres"
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+)
+
+// postgresTestDB returns a connection to a fresh, migrated schema of the
+// database in USERS_TEST_DATABASE_URL, dropped when the test ends. Tests
+// needing it are skipped when the variable is not set.
+func postgresTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("USERS_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("USERS_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("users_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		admin.Close()
+		t.Fatalf("creating the test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close()
+	})
+
+	// Extensions such as pg_trgm may already live in public.
+	conn, err := sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := db.NewMigrator(conn)
+	if err == nil {
+		err = migrator.Up(ctx)
+	}
+	if err != nil {
+		t.Fatalf("migrating the test schema: %v", err)
+	}
+	return conn
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or a
+// key=value connection string.
+func withSearchPath(dsn, searchPath string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + searchPath
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	query := u.Query()
+	query.Set("search_path", searchPath)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// TestPostgresKeysetPaginationParity checks that Postgres orders and pages
+// users sorted on nullable columns exactly as the memory backend does, which
+// exercises the NULLS FIRST and NULLS LAST keyset conditions for real.
+func TestPostgresKeysetPaginationParity(t *testing.T) {
+	conn := postgresTestDB(t)
+	ctx := context.Background()
+
+	users := paginationTestUsers()
+	insert := `INSERT INTO users (id, email, first_name, last_name, created_at, updated_at, deleted_at, version, external_source, external_id)
+			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	for _, user := range users {
+		_, err := conn.ExecContext(ctx, insert, user.ID, user.Email, user.FirstName, user.LastName, user.CreatedAt,
+			user.UpdatedAt, user.DeletedAt, user.Version, nullText(user.ExternalSource), nullText(user.ExternalID))
+		if err != nil {
+			t.Fatalf("inserting user %s: %v", user.ID, err)
+		}
+	}
+
+	memory := paginationTestRepository(t)
+	postgres := NewUsersRepository(conn)
+
+	sorts := []string{
+		"deleted_at",
+		"-deleted_at",
+		"deleted_at:nulls_first",
+		"-deleted_at:nulls_first,-version,first_name",
+		"-last_name,deleted_at",
+		"external_source,-external_id:nulls_first,created_at",
+		"-external_id,version",
+		"external_source:nulls_first,-deleted_at",
+	}
+	for _, s := range sorts {
+		sort, err := domain.ParseSort(s)
+		if err != nil {
+			t.Fatalf("ParseSort(%q) error = %v", s, err)
+		}
+
+		all, err := memory.GetUsersList(ctx, domain.GetUsersInput{Limit: 100, IncludeDeleted: true, Sort: sort})
+		if err != nil {
+			t.Fatalf("sort %s: memory GetUsersList() error = %v", s, err)
+		}
+		want := userIDs(all.Elements)
+
+		for _, limit := range []int{2, 5, 100} {
+			t.Run(fmt.Sprintf("%s/limit=%d", s, limit), func(t *testing.T) {
+				input := domain.GetUsersInput{Limit: limit, IncludeDeleted: true, Sort: sort}
+				forward, backward := pageThrough(t, postgres, input, len(users))
+				if !slices.Equal(forward, want) {
+					t.Fatalf("postgres forward pages = %v, memory order %v", forward, want)
+				}
+				if !slices.Equal(backward, want) {
+					t.Fatalf("postgres backward pages = %v, memory order %v", backward, want)
+				}
+			})
+		}
+	}
+}
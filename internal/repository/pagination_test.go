@@ -0,0 +1,309 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+// paginationTestUsers returns users with repeated names and versions, some
+// of them deleted and some with an external id, so that sorts hit ties and
+// nulls on every key.
+func paginationTestUsers() []domain.User {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastNames := []string{"Brown", "Jones", "Smith"}
+	users := make([]domain.User, 23)
+	for i := range users {
+		user := domain.User{
+			ID:        uuid.MustParse(fmt.Sprintf("00000000-0000-4000-8000-%012d", (i*7)%23)),
+			Email:     fmt.Sprintf("user%02d@example.com", i),
+			FirstName: fmt.Sprintf("First%d", i%4),
+			LastName:  lastNames[i%3],
+			CreatedAt: base.Add(time.Duration(i%5) * time.Hour),
+			UpdatedAt: base.Add(time.Duration(i) * time.Minute),
+			Version:   int64(i%3 + 1),
+		}
+		if i%3 == 0 {
+			deletedAt := base.Add(time.Duration(i%2) * time.Hour)
+			user.DeletedAt = &deletedAt
+		}
+		if i%4 != 0 {
+			user.ExternalSource = []string{"billing", "okta"}[i%2]
+			user.ExternalID = fmt.Sprintf("ext-%02d", i/2)
+		}
+		users[i] = user
+	}
+	return users
+}
+
+// paginationTestRepository returns a memory repository holding the
+// paginationTestUsers.
+func paginationTestRepository(t *testing.T) *usersMemoryRepository {
+	t.Helper()
+
+	store := NewMemoryStore()
+	for _, user := range paginationTestUsers() {
+		store.users[user.ID] = user
+	}
+	return NewUsersMemoryRepository(store)
+}
+
+// inOrder reports whether a comes no later than b in sort, comparing the
+// domain values of the users rather than their rendered keys.
+func inOrder(a, b domain.User, sort domain.SortSpec) bool {
+	keys := slices.Clone(sort)
+	if tiebreak, ok := sort.Tiebreak(); ok {
+		keys = append(keys, tiebreak)
+	}
+	for _, key := range keys {
+		va, vb := a.Field(key.Field), b.Field(key.Field)
+		var c int
+		switch {
+		case va == nil && vb == nil:
+			continue
+		case va == nil || vb == nil:
+			// Nulls are placed regardless of the direction.
+			if (va == nil) == key.NullsFirst {
+				return true
+			}
+			return false
+		}
+		switch va := va.(type) {
+		case time.Time:
+			c = va.Compare(vb.(time.Time))
+		case int64:
+			c = compareInt64(va, vb.(int64))
+		case uuid.UUID:
+			c = strings.Compare(va.String(), vb.(uuid.UUID).String())
+		default:
+			c = strings.Compare(va.(string), vb.(string))
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return true
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func userIDs(users []domain.User) []uuid.UUID {
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func TestMemoryKeysetPagination(t *testing.T) {
+	sorts := []string{
+		"created_at",
+		"-last_name,deleted_at",
+		"-last_name,deleted_at:nulls_first",
+		"-deleted_at,first_name",
+		"-deleted_at:nulls_first,-version,first_name",
+		"external_source,-external_id:nulls_first,created_at",
+		"-external_id,version:nulls_first",
+		"version,-id",
+	}
+	ctx := context.Background()
+	repo := paginationTestRepository(t)
+
+	for _, s := range sorts {
+		sort, err := domain.ParseSort(s)
+		if err != nil {
+			t.Fatalf("ParseSort(%q) error = %v", s, err)
+		}
+
+		all, err := repo.GetUsersList(ctx, domain.GetUsersInput{Limit: 100, IncludeDeleted: true, Sort: sort})
+		if err != nil {
+			t.Fatalf("sort %s: GetUsersList() error = %v", s, err)
+		}
+		if len(all.Elements) != 23 {
+			t.Fatalf("sort %s: got %d users, want 23", s, len(all.Elements))
+		}
+		for i := 1; i < len(all.Elements); i++ {
+			if !inOrder(all.Elements[i-1], all.Elements[i], sort) {
+				t.Fatalf("sort %s: users %d and %d are out of order", s, i-1, i)
+			}
+		}
+		want := userIDs(all.Elements)
+
+		for _, limit := range []int{1, 2, 5, 7} {
+			t.Run(fmt.Sprintf("%s/limit=%d", s, limit), func(t *testing.T) {
+				input := domain.GetUsersInput{Limit: limit, IncludeDeleted: true, Sort: sort}
+				forward, backward := pageThrough(t, repo, input, len(want))
+				if !slices.Equal(forward, want) {
+					t.Fatalf("forward pages = %v, want %v", forward, want)
+				}
+				if !slices.Equal(backward, want) {
+					t.Fatalf("backward pages = %v, want %v", backward, want)
+				}
+			})
+		}
+	}
+}
+
+// pageThrough pages through the users of input forward from the first
+// page, then backward from the last one, and returns the ids seen each way
+// in list order. It fails after more pages than total users.
+func pageThrough(t *testing.T, repo UsersRepository, input domain.GetUsersInput, total int) (forward, backward []uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+
+	var last *domain.List[domain.User]
+	for page := 0; ; page++ {
+		if page > total {
+			t.Fatal("forward pagination does not end")
+		}
+		list, err := repo.GetUsersList(ctx, input)
+		if err != nil {
+			t.Fatalf("GetUsersList() error = %v", err)
+		}
+		forward = append(forward, userIDs(list.Elements)...)
+		last = list
+		if list.NextCursor == "" {
+			break
+		}
+		input.Cursor = &list.NextCursor
+	}
+
+	backward = userIDs(last.Elements)
+	prev := last.PrevCursor
+	for page := 0; prev != ""; page++ {
+		if page > total {
+			t.Fatal("backward pagination does not end")
+		}
+		input.Cursor = &prev
+		list, err := repo.GetUsersList(ctx, input)
+		if err != nil {
+			t.Fatalf("GetUsersList() error = %v", err)
+		}
+		if len(list.Elements) == 0 {
+			t.Fatal("backward page is empty")
+		}
+		backward = append(userIDs(list.Elements), backward...)
+		prev = list.PrevCursor
+	}
+	return forward, backward
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{sort: "created_at", want: " ORDER BY created_at ASC, id ASC"},
+		{sort: "-last_name,deleted_at", want: " ORDER BY last_name DESC, deleted_at ASC NULLS LAST, id ASC"},
+		{sort: "-deleted_at:nulls_first", want: " ORDER BY deleted_at DESC NULLS FIRST, id DESC"},
+		{sort: "version,-id", want: " ORDER BY version ASC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		sort, err := domain.ParseSort(tt.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, err := (&usersFilter{}).sortColumns(sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := orderBy(columns); got != tt.want {
+			t.Errorf("orderBy(%s) = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestUsersFilterAfter(t *testing.T) {
+	value := func(s string) *string { return &s }
+	id := "00000000-0000-4000-8000-000000000001"
+
+	tests := []struct {
+		name     string
+		sort     string
+		values   []*string
+		want     string
+		wantArgs []any
+	}{
+		{
+			name:     "ascending with tiebreaker",
+			sort:     "created_at",
+			values:   []*string{value("2024-01-01"), &id},
+			want:     "(created_at > $1 OR created_at = $2 AND id > $3)",
+			wantArgs: []any{"2024-01-01", "2024-01-01", id},
+		},
+		{
+			name:     "mixed directions",
+			sort:     "-last_name,first_name",
+			values:   []*string{value("Smith"), value("John"), &id},
+			want:     "(last_name < $1 OR last_name = $2 AND first_name > $3 OR last_name = $2 AND first_name = $4 AND id > $5)",
+			wantArgs: []any{"Smith", "Smith", "John", "John", id},
+		},
+		{
+			name:     "value with nulls last",
+			sort:     "deleted_at",
+			values:   []*string{value("2024-01-01"), &id},
+			want:     "((deleted_at > $1 OR deleted_at IS NULL) OR deleted_at = $2 AND id > $3)",
+			wantArgs: []any{"2024-01-01", "2024-01-01", id},
+		},
+		{
+			name:     "null with nulls last",
+			sort:     "-deleted_at",
+			values:   []*string{nil, &id},
+			want:     "(deleted_at IS NULL AND id < $1)",
+			wantArgs: []any{id},
+		},
+		{
+			name:     "null with nulls first",
+			sort:     "deleted_at:nulls_first",
+			values:   []*string{nil, &id},
+			want:     "(deleted_at IS NOT NULL OR deleted_at IS NULL AND id > $1)",
+			wantArgs: []any{id},
+		},
+		{
+			name:     "value with nulls first",
+			sort:     "-deleted_at:nulls_first",
+			values:   []*string{value("2024-01-01"), &id},
+			want:     "(deleted_at < $1 OR deleted_at = $2 AND id < $3)",
+			wantArgs: []any{"2024-01-01", "2024-01-01", id},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort, err := domain.ParseSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &usersFilter{}
+			columns, err := f.sortColumns(sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.after(columns, tt.values)
+			if got := strings.Join(f.conditions, " AND "); got != tt.want {
+				t.Errorf("after() = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(f.args, tt.wantArgs) {
+				t.Errorf("after() args = %v, want %v", f.args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TxManager runs a function atomically. Repository methods called with the
+// context handed to fn take part in the transaction; called with any other
+// context they run on their own.
+type TxManager interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+// dbExecutor is the part of *sql.DB and *sql.Tx the repositories use.
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// executor returns the transaction carried by ctx, or db outside of one.
+func executor(ctx context.Context, db *sql.DB) dbExecutor {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+type txManager struct {
+	db *sql.DB
+}
+
+func NewTxManager(db *sql.DB) *txManager {
+	return &txManager{
+		db: db,
+	}
+}
+
+// WithinTransaction runs fn in a transaction that is committed if fn returns
+// nil and rolled back otherwise. Nested calls join the outer transaction.
+func (m *txManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError("error starting transaction", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError("error committing transaction", err)
+	}
+	return nil
+}
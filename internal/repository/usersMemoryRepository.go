@@ -0,0 +1,520 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ExonegeS/REST-API-001/internal/domain"
+	"github.com/google/uuid"
+)
+
+type usersMemoryRepository struct {
+	store *MemoryStore
+}
+
+// NewUsersMemoryRepository returns a UsersRepository backed by store, with
+// the same semantics as the Postgres one.
+func NewUsersMemoryRepository(store *MemoryStore) *usersMemoryRepository {
+	return &usersMemoryRepository{
+		store: store,
+	}
+}
+
+// memorySortKey renders the sort key of a user so that keys of one field
+// compare the same way their values do. It returns nil when the user has no
+// value for the field.
+func memorySortKey(user domain.User, field string, query string) *string {
+	var key string
+	switch field {
+	case domain.RelevanceOrder:
+		key = fmt.Sprintf("%.15f", memoryRelevance(user, query))
+	case "id":
+		key = user.ID.String()
+	case "email":
+		key = user.Email
+	case "first_name":
+		key = user.FirstName
+	case "last_name":
+		key = user.LastName
+	case "created_at":
+		key = user.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	case "updated_at":
+		key = user.UpdatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	case "deleted_at":
+		if user.DeletedAt == nil {
+			return nil
+		}
+		key = user.DeletedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	case "version":
+		key = fmt.Sprintf("%020d", user.Version)
+	case "external_source", "external_id":
+		key = user.ExternalSource
+		if field == "external_id" {
+			key = user.ExternalID
+		}
+		if key == "" {
+			return nil
+		}
+	}
+	return &key
+}
+
+// memorySortKeys returns the sort keys of a user for each field of sort.
+func memorySortKeys(user domain.User, sort domain.SortSpec, query string) []*string {
+	keys := make([]*string, len(sort))
+	for i, key := range sort {
+		keys[i] = memorySortKey(user, key.Field, query)
+	}
+	return keys
+}
+
+// memorySimilarityThreshold mirrors pg_trgm.word_similarity_threshold as set
+// on Postgres connections.
+const memorySimilarityThreshold = 0.3
+
+func memorySearchText(user domain.User) string {
+	return strings.ToLower(user.FirstName + " " + user.LastName + " " + user.Email)
+}
+
+// memoryMatches approximates the Postgres search: every query word is a
+// word of the user, the query is a substring, or it is similar enough.
+func memoryMatches(user domain.User, query string) bool {
+	text := memorySearchText(user)
+	query = strings.ToLower(query)
+	return strings.Contains(text, query) ||
+		memoryWordMatch(text, query) == 1 ||
+		trigramWordSimilarity(query, text) >= memorySimilarityThreshold
+}
+
+func memoryRelevance(user domain.User, query string) float64 {
+	text := memorySearchText(user)
+	query = strings.ToLower(query)
+	return memoryWordMatch(text, query) + trigramWordSimilarity(query, text)
+}
+
+// memoryWordMatch returns the share of query words found among the words of
+// text.
+func memoryWordMatch(text, query string) float64 {
+	queryWords := strings.Fields(query)
+	if len(queryWords) == 0 {
+		return 0
+	}
+
+	words := strings.Fields(text)
+	found := 0
+	for _, word := range queryWords {
+		if slices.Contains(words, word) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(queryWords))
+}
+
+// trigrams returns the pg_trgm style trigrams of s: each alphanumeric word
+// is padded with two spaces in front and one behind.
+func trigrams(s string) map[string]struct{} {
+	set := map[string]struct{}{}
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
+
+// trigramWordSimilarity is the share of the trigrams of query found in
+// text, an approximation of pg_trgm's word_similarity.
+func trigramWordSimilarity(query, text string) float64 {
+	queryTrigrams := trigrams(query)
+	if len(queryTrigrams) == 0 {
+		return 0
+	}
+
+	textTrigrams := trigrams(text)
+	shared := 0
+	for trigram := range queryTrigrams {
+		if _, ok := textTrigrams[trigram]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(queryTrigrams))
+}
+
+// compareKeyset orders users by their sort keys and IDs the way a keyset
+// query with sort does.
+func compareKeyset(aKeys []*string, aID uuid.UUID, bKeys []*string, bID uuid.UUID, sort domain.SortSpec) int {
+	for i, key := range sort {
+		if c := compareSortKey(aKeys[i], bKeys[i], key); c != 0 {
+			return c
+		}
+	}
+	tiebreak, ok := sort.Tiebreak()
+	if !ok {
+		return 0
+	}
+	a, b := aID.String(), bID.String()
+	return compareSortKey(&a, &b, tiebreak)
+}
+
+func compareSortKey(a, b *string, key domain.SortKey) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil || b == nil:
+		if (a == nil) == key.NullsFirst {
+			return -1
+		}
+		return 1
+	}
+	c := strings.Compare(*a, *b)
+	if key.Desc {
+		return -c
+	}
+	return c
+}
+
+func (u *usersMemoryRepository) GetUsersList(ctx context.Context, input domain.GetUsersInput) (*domain.List[domain.User], error) {
+	sort := input.SortOrder()
+
+	var cursor *domain.Cursor
+	if input.Cursor != nil {
+		var err error
+		cursor, err = domain.DecodeCursor(*input.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A backward page is fetched in reverse order from its cursor.
+	fetchSort := sort
+	if cursor != nil && cursor.Backward {
+		fetchSort = sort.Reverse()
+	}
+
+	defer u.store.rlock(ctx)()
+
+	matched := u.matchingUsers(input, fetchSort)
+
+	var query string
+	if input.Query != nil {
+		query = *input.Query
+	}
+
+	page := matched
+	if cursor != nil {
+		start := len(page)
+		for i, user := range page {
+			if compareKeyset(memorySortKeys(user, fetchSort, query), user.ID, cursor.Keys, cursor.ID, fetchSort) > 0 {
+				start = i
+				break
+			}
+		}
+		page = page[start:]
+	} else {
+		page = page[min(input.Offset, len(page)):]
+	}
+	page = page[:min(input.Limit+1, len(page))]
+
+	users := make([]domain.User, 0, len(page))
+	keys := make([][]*string, 0, len(page))
+	for _, user := range page {
+		users = append(users, copyUser(user))
+		keys = append(keys, memorySortKeys(user, sort, query))
+	}
+
+	usersList := keysetPage(users, keys, input, sort, cursor)
+	usersList.Total = int64(len(matched))
+
+	return &usersList, nil
+}
+
+func (u *usersMemoryRepository) StreamUsers(ctx context.Context, input domain.GetUsersInput, fn func(user domain.User) error) error {
+	unlock := u.store.rlock(ctx)
+	matched := u.matchingUsers(input, input.SortOrder())
+	unlock()
+
+	for _, user := range matched {
+		if err := fn(copyUser(user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchingUsers returns the users matching the filters of input, ordered
+// by sort. The caller must hold the store lock.
+func (u *usersMemoryRepository) matchingUsers(input domain.GetUsersInput, sort domain.SortSpec) []domain.User {
+	var query string
+	if input.Query != nil {
+		query = *input.Query
+	}
+
+	matched := []domain.User{}
+	for _, user := range u.store.users {
+		if !input.IncludeDeleted && user.DeletedAt != nil {
+			continue
+		}
+		if query != "" && !memoryMatches(user, query) {
+			continue
+		}
+		if !domain.MatchesFilters(user, input.Filters) {
+			continue
+		}
+		matched = append(matched, user)
+	}
+
+	slices.SortFunc(matched, func(a, b domain.User) int {
+		return compareKeyset(memorySortKeys(a, sort, query), a.ID, memorySortKeys(b, sort, query), b.ID, sort)
+	})
+
+	return matched
+}
+
+func (u *usersMemoryRepository) GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.User, error) {
+	defer u.store.rlock(ctx)()
+
+	for _, user := range u.store.users {
+		if input.ID != nil && user.ID.String() != strings.ToLower(*input.ID) {
+			continue
+		}
+		if input.Email != nil && domain.EmailKey(user.Email) != domain.EmailKey(*input.Email) {
+			continue
+		}
+		if input.ExternalSource != nil && input.ExternalID != nil &&
+			(user.ExternalSource != *input.ExternalSource || user.ExternalID != *input.ExternalID) {
+			continue
+		}
+		if !input.IncludeDeleted && user.DeletedAt != nil {
+			continue
+		}
+		user = copyUser(user)
+		return &user, nil
+	}
+
+	return nil, domain.UserNotFound("user not found")
+}
+
+func (u *usersMemoryRepository) GetUserForUpdate(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	defer u.store.rlock(ctx)()
+
+	user, ok := u.store.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, domain.UserNotFound("user with id %s not found", id)
+	}
+
+	user = copyUser(user)
+	return &user, nil
+}
+
+// GetUsersByEmails ignores lock: transactions hold the whole store.
+func (u *usersMemoryRepository) GetUsersByEmails(ctx context.Context, emails []string, lock bool) ([]domain.User, error) {
+	defer u.store.rlock(ctx)()
+
+	users := []domain.User{}
+	for _, user := range u.store.users {
+		if containsEmail(emails, user.Email) {
+			users = append(users, copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+func (u *usersMemoryRepository) GetUsersByKeys(ctx context.Context, ids []uuid.UUID, emails []string, includeDeleted bool, fields []string) ([]domain.User, error) {
+	defer u.store.rlock(ctx)()
+
+	users := []domain.User{}
+	for _, user := range u.store.users {
+		if !includeDeleted && user.DeletedAt != nil {
+			continue
+		}
+		if slices.Contains(ids, user.ID) || containsEmail(emails, user.Email) {
+			users = append(users, copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+func (u *usersMemoryRepository) InsertUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	defer u.store.lock(ctx)()
+
+	if existingUser := u.store.userByEmail(user.Email); existingUser != nil {
+		if existingUser.DeletedAt != nil {
+			return nil, domain.EmailInUse("email already in use by a deleted user")
+		}
+		return nil, domain.EmailInUse("email already in use")
+	}
+	if u.store.userByExternalID(user.ExternalSource, user.ExternalID) != nil {
+		return nil, domain.ExternalIDInUse("external id already in use")
+	}
+
+	user.ID = uuid.New()
+	user.Version = 1
+	u.store.users[user.ID] = copyUser(*user)
+
+	return user, nil
+}
+
+func (u *usersMemoryRepository) InsertUsers(ctx context.Context, users []domain.User) ([]domain.User, error) {
+	defer u.store.lock(ctx)()
+
+	created := make([]domain.User, 0, len(users))
+	for _, user := range users {
+		if u.store.userByEmail(user.Email) != nil || u.store.userByExternalID(user.ExternalSource, user.ExternalID) != nil {
+			continue
+		}
+
+		user.ID = uuid.New()
+		user.Version = 1
+		u.store.users[user.ID] = copyUser(user)
+		created = append(created, user)
+	}
+
+	return created, nil
+}
+
+func (u *usersMemoryRepository) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	defer u.store.lock(ctx)()
+
+	existingUser, ok := u.store.users[user.ID]
+	if !ok || existingUser.DeletedAt != nil {
+		return nil, domain.UserNotFound("user with id %s not found", user.ID)
+	}
+	if existingUser.Version != user.Version {
+		return nil, domain.ErrVersionConflict
+	}
+
+	if other := u.store.userByEmail(user.Email); other != nil && other.ID != user.ID {
+		return nil, domain.EmailInUse("email already in use")
+	}
+	if other := u.store.userByExternalID(user.ExternalSource, user.ExternalID); other != nil && other.ID != user.ID {
+		return nil, domain.ExternalIDInUse("external id already in use")
+	}
+
+	existingUser.Email = user.Email
+	existingUser.FirstName = user.FirstName
+	existingUser.LastName = user.LastName
+	existingUser.ExternalSource = user.ExternalSource
+	existingUser.ExternalID = user.ExternalID
+	existingUser.UpdatedAt = user.UpdatedAt
+	existingUser.Version++
+	u.store.users[user.ID] = existingUser
+
+	*user = copyUser(existingUser)
+	return user, nil
+}
+
+func (u *usersMemoryRepository) UpsertUser(ctx context.Context, user *domain.User) (*domain.User, bool, error) {
+	defer u.store.lock(ctx)()
+
+	existingUser := u.store.userByEmail(user.Email)
+	if other := u.store.userByExternalID(user.ExternalSource, user.ExternalID); other != nil && (existingUser == nil || other.ID != existingUser.ID) {
+		return nil, false, domain.ExternalIDInUse("external id already in use")
+	}
+
+	if existingUser == nil {
+		stored := copyUser(*user)
+		stored.ID = uuid.New()
+		stored.Version = 1
+		u.store.users[stored.ID] = stored
+		return &stored, true, nil
+	}
+
+	if existingUser.DeletedAt != nil ||
+		(existingUser.Email == user.Email && existingUser.FirstName == user.FirstName && existingUser.LastName == user.LastName &&
+			existingUser.ExternalSource == user.ExternalSource && existingUser.ExternalID == user.ExternalID) {
+		return nil, false, nil
+	}
+
+	stored := copyUser(*existingUser)
+	stored.Email = user.Email
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.ExternalSource = user.ExternalSource
+	stored.ExternalID = user.ExternalID
+	stored.UpdatedAt = user.UpdatedAt
+	stored.Version++
+	u.store.users[stored.ID] = stored
+
+	return &stored, false, nil
+}
+
+func (u *usersMemoryRepository) SoftDeleteUser(ctx context.Context, id uuid.UUID, deletedAt time.Time) error {
+	defer u.store.lock(ctx)()
+
+	user, ok := u.store.users[id]
+	if !ok || user.DeletedAt != nil {
+		return domain.UserNotFound("user with id %s not found", id)
+	}
+
+	user.DeletedAt = &deletedAt
+	user.UpdatedAt = deletedAt
+	user.Version++
+	u.store.users[id] = user
+
+	return nil
+}
+
+func (u *usersMemoryRepository) RestoreUser(ctx context.Context, id uuid.UUID, restoredAt time.Time) (*domain.User, error) {
+	defer u.store.lock(ctx)()
+
+	user, ok := u.store.users[id]
+	if !ok || user.DeletedAt == nil {
+		return nil, domain.UserNotFound("deleted user with id %s not found", id)
+	}
+
+	user.DeletedAt = nil
+	user.UpdatedAt = restoredAt
+	user.Version++
+	u.store.users[id] = user
+
+	return &user, nil
+}
+
+func (u *usersMemoryRepository) PurgeUser(ctx context.Context, id uuid.UUID) error {
+	defer u.store.lock(ctx)()
+
+	if _, ok := u.store.users[id]; !ok {
+		return domain.UserNotFound("user with id %s not found", id)
+	}
+	delete(u.store.users, id)
+
+	return nil
+}
+
+func (u *usersMemoryRepository) PurgeDeletedUsersByEmail(ctx context.Context, emails []string, deletedBefore time.Time) ([]domain.User, error) {
+	defer u.store.lock(ctx)()
+
+	purged := []domain.User{}
+	for id, user := range u.store.users {
+		if containsEmail(emails, user.Email) && user.DeletedAt != nil && !user.DeletedAt.After(deletedBefore) {
+			delete(u.store.users, id)
+			purged = append(purged, user)
+		}
+	}
+
+	return purged, nil
+}
+
+// containsEmail reports whether emails holds email, ignoring case.
+func containsEmail(emails []string, email string) bool {
+	return slices.ContainsFunc(emails, func(e string) bool {
+		return domain.EmailKey(e) == domain.EmailKey(email)
+	})
+}
+
+// copyUser detaches the pointer fields of user from the stored value.
+func copyUser(user domain.User) domain.User {
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+	return user
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/ExonegeS/REST-API-001/internal/domain"
 	"github.com/lib/pq"
@@ -13,9 +17,125 @@ import (
 
 type UsersRepository interface {
 	GetUsersList(ctx context.Context, input domain.GetUsersInput) (*domain.List[domain.User], error)
+	// StreamUsers calls fn for every user matching the filters of input, in
+	// its order, ignoring its pagination. It stops at the first error of fn.
+	StreamUsers(ctx context.Context, input domain.GetUsersInput, fn func(user domain.User) error) error
 	GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.User, error)
+	// GetUserForUpdate returns an active user and, inside a transaction,
+	// locks it until the transaction ends.
+	GetUserForUpdate(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	// GetUsersByEmails returns the users, deleted or not, holding one of
+	// emails. With lock set and inside a transaction, the users stay locked
+	// until the transaction ends.
+	GetUsersByEmails(ctx context.Context, emails []string, lock bool) ([]domain.User, error)
+	// GetUsersByKeys returns the users with one of ids or holding one of
+	// emails, in no particular order. A non-nil fields restricts the users
+	// to those fields, their id and their email.
+	GetUsersByKeys(ctx context.Context, ids []uuid.UUID, emails []string, includeDeleted bool, fields []string) ([]domain.User, error)
 	InsertUser(ctx context.Context, user *domain.User) (*domain.User, error)
 	UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error)
+	// UpsertUser inserts user, or updates the active user holding its email
+	// when any of its writable fields differ. It returns the stored user and
+	// whether it was inserted, or a nil user when nothing was written.
+	UpsertUser(ctx context.Context, user *domain.User) (*domain.User, bool, error)
+	SoftDeleteUser(ctx context.Context, id uuid.UUID, deletedAt time.Time) error
+	RestoreUser(ctx context.Context, id uuid.UUID, restoredAt time.Time) (*domain.User, error)
+	PurgeUser(ctx context.Context, id uuid.UUID) error
+	// InsertUsers inserts users in bulk and returns the ones created. Users
+	// whose email is already in use are skipped.
+	InsertUsers(ctx context.Context, users []domain.User) ([]domain.User, error)
+	// PurgeDeletedUsersByEmail hard-deletes users holding one of emails that
+	// were soft-deleted at or before deletedBefore, releasing the addresses.
+	// It returns the purged users.
+	PurgeDeletedUsersByEmail(ctx context.Context, emails []string, deletedBefore time.Time) ([]domain.User, error)
+}
+
+// usersColumns selects every field of a user.
+var usersColumns = userColumns(domain.UserFields)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser scans a row selected with usersColumns, followed by extra columns.
+func scanUser(row rowScanner, user *domain.User, extra ...any) error {
+	return scanUserFields(row, user, domain.UserFields, extra...)
+}
+
+// selectedUserFields returns the fields to select for a sparse fieldset: the
+// requested ones and id, which identifies the rows, in the order of
+// UserFields. Without a fieldset every field is selected.
+func selectedUserFields(fields []string) []string {
+	if fields == nil {
+		return domain.UserFields
+	}
+	selected := []string{}
+	for _, field := range domain.UserFields {
+		if field == "id" || slices.Contains(fields, field) {
+			selected = append(selected, field)
+		}
+	}
+	return selected
+}
+
+// userColumns returns the select list of fields. The columns of users are
+// named after the fields.
+func userColumns(fields []string) string {
+	return strings.Join(fields, ", ")
+}
+
+// scanUserFields scans a row selected with userColumns(fields), followed by
+// extra columns. The fields that weren't selected are left zero.
+func scanUserFields(row rowScanner, user *domain.User, fields []string, extra ...any) error {
+	dest := make([]any, 0, len(fields)+len(extra))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			dest = append(dest, &user.ID)
+		case "email":
+			dest = append(dest, &user.Email)
+		case "first_name":
+			dest = append(dest, &user.FirstName)
+		case "last_name":
+			dest = append(dest, &user.LastName)
+		case "created_at":
+			dest = append(dest, &user.CreatedAt)
+		case "updated_at":
+			dest = append(dest, &user.UpdatedAt)
+		case "deleted_at":
+			dest = append(dest, &user.DeletedAt)
+		case "version":
+			dest = append(dest, &user.Version)
+		case "external_source":
+			dest = append(dest, optionalText{&user.ExternalSource})
+		case "external_id":
+			dest = append(dest, optionalText{&user.ExternalID})
+		default:
+			return fmt.Errorf("unknown user field %q", field)
+		}
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// optionalText scans a nullable text column into a string that is left
+// empty for NULL.
+type optionalText struct {
+	s *string
+}
+
+func (t optionalText) Scan(src any) error {
+	var value sql.NullString
+	if err := value.Scan(src); err != nil {
+		return err
+	}
+	*t.s = value.String
+	return nil
+}
+
+// nullText returns the value stored for an optional text field: NULL when
+// it is empty.
+func nullText(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 type usersRepository struct {
@@ -28,33 +148,386 @@ func NewUsersRepository(db *sql.DB) *usersRepository {
 	}
 }
 
+func (u *usersRepository) conn(ctx context.Context) dbExecutor {
+	return executor(ctx, u.db)
+}
+
+// usersSortColumn is the column of a sortable domain field.
+type usersSortColumn struct {
+	expr     string
+	nullable bool
+}
+
+// usersSortColumns maps the sortable domain fields to their columns.
+var usersSortColumns = map[string]usersSortColumn{
+	"id":         {expr: "id"},
+	"email":      {expr: "email"},
+	"first_name": {expr: "first_name"},
+	"last_name":  {expr: "last_name"},
+	"created_at": {expr: "created_at"},
+	"updated_at": {expr: "updated_at"},
+	"deleted_at": {expr: "deleted_at", nullable: true},
+	"version":    {expr: "version"},
+
+	"external_source": {expr: "external_source", nullable: true},
+	"external_id":     {expr: "external_id", nullable: true},
+}
+
+// emailKeys returns the keys of emails, to match them against the
+// lower(email) unique index.
+func emailKeys(emails []string) []string {
+	keys := make([]string, len(emails))
+	for i, email := range emails {
+		keys[i] = domain.EmailKey(email)
+	}
+	return keys
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// usersSearchText is the text searched by the query parameter. It must match
+// the expression of the users_search_trgm_idx index.
+const usersSearchText = "(first_name || ' ' || last_name || ' ' || email)"
+
+// usersFilter holds the WHERE conditions and bind arguments of a users list
+// query, plus the relevance expression when the list is searched.
+type usersFilter struct {
+	conditions []string
+	args       []interface{}
+	relevance  string
+}
+
+func newUsersFilter(input domain.GetUsersInput) *usersFilter {
+	f := &usersFilter{conditions: []string{}}
+
+	// The query matches full-text, as a substring, or fuzzily through
+	// trigram word similarity, which catches typos such as "jonh".
+	if input.Query != nil {
+		query, like := f.arg(*input.Query), f.arg("%"+likeEscaper.Replace(*input.Query)+"%")
+		f.conditions = append(f.conditions, "(search @@ websearch_to_tsquery('simple', "+query+")"+
+			" OR "+usersSearchText+" ILIKE "+like+
+			" OR "+query+" <% "+usersSearchText+")")
+		f.relevance = "(ts_rank(search, websearch_to_tsquery('simple', " + query + ")) + word_similarity(" + query + ", " + usersSearchText + "))::float8"
+	}
+
+	if !input.IncludeDeleted {
+		f.conditions = append(f.conditions, "deleted_at IS NULL")
+	}
+
+	for _, filter := range input.Filters {
+		f.conditions = append(f.conditions, f.compile(filter))
+	}
+
+	return f
+}
+
+// usersFilterOperators maps the comparison operators of filters to SQL.
+var usersFilterOperators = map[domain.FilterOp]string{
+	domain.FilterEq:  "=",
+	domain.FilterGt:  ">",
+	domain.FilterGte: ">=",
+	domain.FilterLt:  "<",
+	domain.FilterLte: "<=",
+}
+
+// compile returns the condition of a validated filter. Prefixes match
+// case-insensitively, as in Filter.Matches.
+func (f *usersFilter) compile(filter domain.Filter) string {
+	switch filter.Field {
+	case "id":
+		values := filter.Value.([]uuid.UUID)
+		ids := make([]string, 0, len(values))
+		for _, id := range values {
+			ids = append(ids, id.String())
+		}
+		condition := "id = ANY(" + f.arg(pq.Array(ids)) + "::uuid[])"
+		if filter.Op == domain.FilterNotIn {
+			condition = "NOT " + condition
+		}
+		return condition
+	case "email_domain":
+		return "split_part(email, '@', 2) = " + f.arg(filter.Value)
+	case "first_name", "last_name":
+		if filter.Op == domain.FilterPrefix {
+			return filter.Field + " ILIKE " + f.arg(likeEscaper.Replace(filter.Value.(string))+"%")
+		}
+	case "created_at", "updated_at":
+		return filter.Field + " " + usersFilterOperators[filter.Op] + " " + f.arg(storageTime(filter.Value.(time.Time)))
+	}
+	return filter.Field + " " + usersFilterOperators[filter.Op] + " " + f.arg(filter.Value)
+}
+
+// storageTime converts t to the zone of the timestamp columns. They hold the
+// wall clock of the server's local time, and Postgres drops the offset of a
+// time bound to them, so comparing an instant in another zone would shift it.
+func storageTime(t time.Time) time.Time {
+	return t.In(time.Local)
+}
+
+// arg adds a bind argument and returns its placeholder.
+func (f *usersFilter) arg(value interface{}) string {
+	f.args = append(f.args, value)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+func (f *usersFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// sortColumn is a key of a sort spec with the column it sorts on.
+type sortColumn struct {
+	domain.SortKey
+	usersSortColumn
+}
+
+// sortColumns returns the columns of sort followed by the id tiebreaker.
+func (f *usersFilter) sortColumns(sort domain.SortSpec) ([]sortColumn, error) {
+	keys := slices.Clone(sort)
+	if tiebreak, ok := sort.Tiebreak(); ok {
+		keys = append(keys, tiebreak)
+	}
+
+	columns := make([]sortColumn, len(keys))
+	for i, key := range keys {
+		if key.Field == domain.RelevanceOrder {
+			if f.relevance == "" {
+				return nil, domain.Invalid(fmt.Errorf("sorting by relevance requires a query"))
+			}
+			columns[i] = sortColumn{key, usersSortColumn{expr: f.relevance}}
+			continue
+		}
+		column, ok := usersSortColumns[key.Field]
+		if !ok {
+			return nil, domain.Invalid(fmt.Errorf("unsupported sort field %q", key.Field))
+		}
+		columns[i] = sortColumn{key, column}
+	}
+	return columns, nil
+}
+
+// orderBy returns the ORDER BY clause of columns. Null placement is only
+// spelled out for nullable columns, so that the others can use plain
+// indexes.
+func orderBy(columns []sortColumn) string {
+	terms := make([]string, len(columns))
+	for i, column := range columns {
+		terms[i] = column.expr + " ASC"
+		if column.Desc {
+			terms[i] = column.expr + " DESC"
+		}
+		if column.nullable {
+			if column.NullsFirst {
+				terms[i] += " NULLS FIRST"
+			} else {
+				terms[i] += " NULLS LAST"
+			}
+		}
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// after adds the condition selecting the rows that come after values in the
+// order of columns: the rows ahead on a column and level on all the ones
+// before it. A nil value is a null.
+func (f *usersFilter) after(columns []sortColumn, values []*string) {
+	var level, alternatives []string
+	for i, column := range columns {
+		value := values[i]
+
+		ahead := ""
+		switch {
+		case value == nil && column.NullsFirst:
+			ahead = column.expr + " IS NOT NULL"
+		case value != nil:
+			operator := ">"
+			if column.Desc {
+				operator = "<"
+			}
+			ahead = column.expr + " " + operator + " " + f.arg(*value)
+			if column.nullable && !column.NullsFirst {
+				ahead = "(" + ahead + " OR " + column.expr + " IS NULL)"
+			}
+		}
+		if ahead != "" {
+			alternatives = append(alternatives, strings.Join(append(slices.Clone(level), ahead), " AND "))
+		}
+
+		// The last column is never level, and binding its value anyway
+		// would leave a parameter Postgres can't infer the type of.
+		if i == len(columns)-1 {
+			break
+		}
+		if value == nil {
+			level = append(level, column.expr+" IS NULL")
+		} else {
+			level = append(level, column.expr+" = "+f.arg(*value))
+		}
+	}
+	f.conditions = append(f.conditions, "("+strings.Join(alternatives, " OR ")+")")
+}
+
+// sortKeys returns the select list of the sort keys of columns, as text,
+// leaving out the id tiebreaker.
+func sortKeys(columns []sortColumn, keys int) string {
+	exprs := make([]string, keys)
+	for i := range keys {
+		exprs[i] = "(" + columns[i].expr + ")::text"
+	}
+	return strings.Join(exprs, ", ")
+}
+
 func (u *usersRepository) GetUsersList(ctx context.Context, input domain.GetUsersInput) (*domain.List[domain.User], error) {
-	rows, err := u.db.QueryContext(ctx, "select * from users")
+	filter := newUsersFilter(input)
+
+	var total int64
+	err := u.conn(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+filter.where(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, dbError("error occured while counting rows in 'users'", err)
+	}
+
+	sort := input.SortOrder()
+	var cursor *domain.Cursor
+	offset := input.Offset
+	if input.Cursor != nil {
+		cursor, err = domain.DecodeCursor(*input.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		offset = 0
+	}
+
+	// A backward page is fetched in reverse order from its cursor.
+	fetchSort := sort
+	if cursor != nil && cursor.Backward {
+		fetchSort = sort.Reverse()
+	}
+	columns, err := filter.sortColumns(fetchSort)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	usersList := domain.List[domain.User]{}
+	if cursor != nil {
+		values := cursor.Keys
+		if len(columns) > len(values) {
+			id := cursor.ID.String()
+			values = append(slices.Clone(values), &id)
+		}
+		filter.after(columns, values)
+	}
+
+	fields := selectedUserFields(input.Fields)
+	query := "SELECT " + userColumns(fields) + ", " + sortKeys(columns, len(sort)) + " FROM users" + filter.where() +
+		orderBy(columns)
+	query += " LIMIT " + filter.arg(input.Limit+1) + " OFFSET " + filter.arg(offset)
 
+	rows, err := u.conn(ctx).QueryContext(ctx, query, filter.args...)
+	if err != nil {
+		return nil, dbError("error occured while querying rows in 'users'", err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	keys := [][]*string{}
 	for rows.Next() {
 		var user domain.User
-		if err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("error occured while scanning rows in 'users': %s", err)
+		key := make([]*string, len(sort))
+		dest := make([]any, len(key))
+		for i := range key {
+			dest[i] = &key[i]
+		}
+		if err := scanUserFields(rows, &user, fields, dest...); err != nil {
+			return nil, dbError("error occured while scanning rows in 'users'", err)
 		}
-		usersList.Elements = append(usersList.Elements, user)
+		users = append(users, user)
+		keys = append(keys, key)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("error occured while reading rows in 'users'", err)
+	}
+
+	usersList := keysetPage(users, keys, input, sort, cursor)
+	usersList.Total = total
+
+	return &usersList, nil
+}
+
+// streamFetchSize is the number of rows fetched from the export cursor at
+// once.
+const streamFetchSize = 500
+
+// streamIdleTimeout ends a stream whose caller takes longer than this to
+// consume a batch of rows, so that slow readers don't keep its transaction
+// and cursor open indefinitely.
+const streamIdleTimeout = "2min"
 
-	err = u.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&usersList.Total)
+// StreamUsers reads the users through a server-side cursor, so that only
+// streamFetchSize rows are held in memory at a time.
+func (u *usersRepository) StreamUsers(ctx context.Context, input domain.GetUsersInput, fn func(user domain.User) error) error {
+	filter := newUsersFilter(input)
+
+	columns, err := filter.sortColumns(input.SortOrder())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &usersList, nil
+	fields := selectedUserFields(input.Fields)
+	query := "SELECT " + userColumns(fields) + " FROM users" + filter.where() + orderBy(columns)
+
+	// Cursors only live inside a transaction.
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	if !ok {
+		tx, err = u.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return dbError("error starting transaction", err)
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "SET LOCAL idle_in_transaction_session_timeout = '"+streamIdleTimeout+"'"); err != nil {
+			return dbError("error setting the stream timeout", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DECLARE users_stream NO SCROLL CURSOR FOR "+query, filter.args...); err != nil {
+		return dbError("error declaring cursor on 'users'", err)
+	}
+	defer tx.ExecContext(context.Background(), "CLOSE users_stream")
+
+	for {
+		rows, err := tx.QueryContext(ctx, "FETCH FORWARD "+strconv.Itoa(streamFetchSize)+" FROM users_stream")
+		if err != nil {
+			return dbError("error fetching rows in 'users'", err)
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			var user domain.User
+			if err := scanUserFields(rows, &user, fields); err != nil {
+				rows.Close()
+				return dbError("error occured while scanning rows in 'users'", err)
+			}
+			if err := fn(user); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return dbError("error occured while reading rows in 'users'", err)
+		}
+
+		if fetched < streamFetchSize {
+			return nil
+		}
+	}
 }
 
 func (u *usersRepository) GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.User, error) {
-	query := "SELECT id, email, first_name, last_name, created_at, updated_at FROM users"
+	fields := selectedUserFields(input.Fields)
+	query := "SELECT " + userColumns(fields) + " FROM users"
 
 	var args []interface{}
 
@@ -65,74 +538,313 @@ func (u *usersRepository) GetUsersOne(ctx context.Context, input domain.GetUserI
 		args = append(args, *input.ID)
 	}
 
+	if input.Email != nil {
+		conditions = append(conditions, "lower(email) = $"+strconv.Itoa(len(args)+1))
+		args = append(args, domain.EmailKey(*input.Email))
+	}
+
+	if input.ExternalSource != nil && input.ExternalID != nil {
+		conditions = append(conditions, "external_source = $"+strconv.Itoa(len(args)+1)+" AND external_id = $"+strconv.Itoa(len(args)+2))
+		args = append(args, *input.ExternalSource, *input.ExternalID)
+	}
+
+	if !input.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	row := u.db.QueryRowContext(ctx, query, args...)
+	row := u.conn(ctx).QueryRowContext(ctx, query, args...)
 
 	var user domain.User
-	if err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := scanUserFields(row, &user, fields); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, domain.UserNotFound("user not found")
 		}
-		return nil, fmt.Errorf("error occurred while scanning row in 'users': %s", err)
+		return nil, dbError("error occurred while scanning row in 'users'", err)
 	}
 
 	return &user, nil
 }
 
+func (u *usersRepository) GetUserForUpdate(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	query := "SELECT " + usersColumns + " FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE"
+
+	var user domain.User
+	if err := scanUser(u.conn(ctx).QueryRowContext(ctx, query, id), &user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.UserNotFound("user with id %s not found", id)
+		}
+		return nil, dbError("error occurred while scanning row in 'users'", err)
+	}
+
+	return &user, nil
+}
+
+func (u *usersRepository) GetUsersByEmails(ctx context.Context, emails []string, lock bool) ([]domain.User, error) {
+	query := "SELECT " + usersColumns + " FROM users WHERE lower(email) = ANY($1)"
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	rows, err := u.conn(ctx).QueryContext(ctx, query, pq.Array(emailKeys(emails)))
+	if err != nil {
+		return nil, dbError("error getting users by email", err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, dbError("error occured while scanning rows in 'users'", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("error getting users by email", err)
+	}
+
+	return users, nil
+}
+
+func (u *usersRepository) GetUsersByKeys(ctx context.Context, ids []uuid.UUID, emails []string, includeDeleted bool, fields []string) ([]domain.User, error) {
+	idStrings := make([]string, len(ids))
+	for i, id := range ids {
+		idStrings[i] = id.String()
+	}
+
+	// The email is kept to match the users to the requested emails.
+	if fields != nil {
+		fields = append(slices.Clone(fields), "email")
+	}
+	fields = selectedUserFields(fields)
+	query := "SELECT " + userColumns(fields) + " FROM users WHERE (id = ANY($1::uuid[]) OR lower(email) = ANY($2))"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
+	rows, err := u.conn(ctx).QueryContext(ctx, query, pq.Array(idStrings), pq.Array(emailKeys(emails)))
+	if err != nil {
+		return nil, dbError("error getting users by keys", err)
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := scanUserFields(rows, &user, fields); err != nil {
+			return nil, dbError("error occured while scanning rows in 'users'", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("error getting users by keys", err)
+	}
+
+	return users, nil
+}
+
 func (u *usersRepository) InsertUser(ctx context.Context, user *domain.User) (*domain.User, error) {
 	var existingUser domain.User
-	err := u.db.QueryRowContext(ctx, "SELECT id, email, first_name, last_name, created_at, updated_at FROM users WHERE email = $1", user.Email).Scan(
-		&existingUser.ID, &existingUser.Email, &existingUser.FirstName, &existingUser.LastName, &existingUser.CreatedAt, &existingUser.UpdatedAt,
-	)
+	err := scanUser(u.conn(ctx).QueryRowContext(ctx, "SELECT "+usersColumns+" FROM users WHERE lower(email) = $1", domain.EmailKey(user.Email)), &existingUser)
 	if err != nil && err != sql.ErrNoRows {
-		return nil, fmt.Errorf("error checking for existing email: %v", err)
+		return nil, dbError("error checking for existing email", err)
 	}
 	if err == nil {
-		return nil, fmt.Errorf("email already in use")
+		if existingUser.DeletedAt != nil {
+			return nil, domain.EmailInUse("email already in use by a deleted user")
+		}
+		return nil, domain.EmailInUse("email already in use")
 	}
 
-	query := `INSERT INTO users (email, first_name, last_name, created_at, updated_at) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err = u.db.QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, user.CreatedAt, user.UpdatedAt).Scan(&user.ID)
+	query := `INSERT INTO users (email, first_name, last_name, external_source, external_id, created_at, updated_at) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, version`
+	err = u.conn(ctx).QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, nullText(user.ExternalSource), nullText(user.ExternalID), user.CreatedAt, user.UpdatedAt).Scan(&user.ID, &user.Version)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return nil, fmt.Errorf("email already in use")
+		if conflict := userConflict(err); conflict != nil {
+			return nil, conflict
 		}
-		return nil, fmt.Errorf("error creating user: %v", err)
+		return nil, dbError("error creating user", err)
 	}
 
 	return user, nil
 }
 
+// insertUsersChunk keeps multi-row inserts well below the limit of 65535
+// bind parameters per statement.
+const insertUsersChunk = 1000
+
+func (u *usersRepository) InsertUsers(ctx context.Context, users []domain.User) ([]domain.User, error) {
+	created := make([]domain.User, 0, len(users))
+
+	for start := 0; start < len(users); start += insertUsersChunk {
+		chunk := users[start:min(start+insertUsersChunk, len(users))]
+
+		values := make([]string, 0, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*7)
+		for _, user := range chunk {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+			args = append(args, user.Email, user.FirstName, user.LastName, nullText(user.ExternalSource), nullText(user.ExternalID), user.CreatedAt, user.UpdatedAt)
+		}
+
+		query := "INSERT INTO users (email, first_name, last_name, external_source, external_id, created_at, updated_at) VALUES " +
+			strings.Join(values, ", ") + " ON CONFLICT DO NOTHING RETURNING " + usersColumns
+
+		rows, err := u.conn(ctx).QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, dbError("error creating users", err)
+		}
+		for rows.Next() {
+			var user domain.User
+			if err := scanUser(rows, &user); err != nil {
+				rows.Close()
+				return nil, dbError("error occured while scanning rows in 'users'", err)
+			}
+			created = append(created, user)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, dbError("error creating users", err)
+		}
+	}
+
+	return created, nil
+}
+
+// UpdateUser stores user if it is still at user.Version, and bumps the
+// version. It fails with domain.ErrVersionConflict if the user was changed in
+// the meantime.
 func (u *usersRepository) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	var existingUser domain.User
-	err := u.db.QueryRowContext(ctx, "SELECT id, email, first_name, last_name, created_at, updated_at FROM users WHERE id = $1", user.ID).Scan(
-		&existingUser.ID, &existingUser.Email, &existingUser.FirstName, &existingUser.LastName, &existingUser.CreatedAt, &existingUser.UpdatedAt,
-	)
+	query := `UPDATE users
+			  SET email = $1, first_name = $2, last_name = $3, external_source = $4, external_id = $5, updated_at = $6, version = version + 1
+			  WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+			  RETURNING ` + usersColumns
+
+	err := scanUser(u.conn(ctx).QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, nullText(user.ExternalSource), nullText(user.ExternalID), user.UpdatedAt, user.ID, user.Version), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user with id %s not found", user.ID)
+			var exists bool
+			err = u.conn(ctx).QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", user.ID).Scan(&exists)
+			if err != nil {
+				return nil, dbError("error updating user", err)
+			}
+			if exists {
+				return nil, domain.ErrVersionConflict
+			}
+			return nil, domain.UserNotFound("user with id %s not found", user.ID)
+		}
+		if conflict := userConflict(err); conflict != nil {
+			return nil, conflict
+		}
+		return nil, dbError("error updating user", err)
+	}
+
+	return user, nil
+}
+
+func (u *usersRepository) UpsertUser(ctx context.Context, user *domain.User) (*domain.User, bool, error) {
+	// xmax is zero for a row this statement inserted rather than updated.
+	query := `INSERT INTO users (email, first_name, last_name, external_source, external_id, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  ON CONFLICT (lower(email)) DO UPDATE
+			  SET email = EXCLUDED.email, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name,
+			      external_source = EXCLUDED.external_source, external_id = EXCLUDED.external_id,
+			      updated_at = EXCLUDED.updated_at, version = users.version + 1
+			  WHERE users.deleted_at IS NULL
+			    AND (users.email, users.first_name, users.last_name, users.external_source, users.external_id)
+			        IS DISTINCT FROM (EXCLUDED.email, EXCLUDED.first_name, EXCLUDED.last_name, EXCLUDED.external_source, EXCLUDED.external_id)
+			  RETURNING ` + usersColumns + `, xmax = 0`
+
+	var stored domain.User
+	var inserted bool
+	row := u.conn(ctx).QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, nullText(user.ExternalSource), nullText(user.ExternalID), user.CreatedAt, user.UpdatedAt)
+	if err := scanUser(row, &stored, &inserted); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
 		}
-		return nil, fmt.Errorf("error checking for existing user: %v", err)
+		if conflict := userConflict(err); conflict != nil {
+			return nil, false, conflict
+		}
+		return nil, false, dbError("error upserting user", err)
 	}
 
+	return &stored, inserted, nil
+}
+
+func (u *usersRepository) SoftDeleteUser(ctx context.Context, id uuid.UUID, deletedAt time.Time) error {
+	result, err := u.conn(ctx).ExecContext(ctx, "UPDATE users SET deleted_at = $1, updated_at = $1, version = version + 1 WHERE id = $2 AND deleted_at IS NULL", deletedAt, id)
+	if err != nil {
+		return dbError("error deleting user", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError("error deleting user", err)
+	}
+	if affected == 0 {
+		return domain.UserNotFound("user with id %s not found", id)
+	}
+
+	return nil
+}
+
+func (u *usersRepository) RestoreUser(ctx context.Context, id uuid.UUID, restoredAt time.Time) (*domain.User, error) {
 	query := `UPDATE users
-			  SET email = $1, first_name = $2, last_name = $3, updated_at = $4
-			  WHERE id = $5
-			  RETURNING id, email, first_name, last_name, created_at, updated_at`
+			  SET deleted_at = NULL, updated_at = $1, version = version + 1
+			  WHERE id = $2 AND deleted_at IS NOT NULL
+			  RETURNING ` + usersColumns
 
-	err = u.db.QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, user.UpdatedAt, user.ID).Scan(
-		&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt,
-	)
+	var user domain.User
+	if err := scanUser(u.conn(ctx).QueryRowContext(ctx, query, restoredAt, id), &user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.UserNotFound("deleted user with id %s not found", id)
+		}
+		return nil, dbError("error restoring user", err)
+	}
+
+	return &user, nil
+}
+
+func (u *usersRepository) PurgeUser(ctx context.Context, id uuid.UUID) error {
+	result, err := u.conn(ctx).ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return nil, fmt.Errorf("the email address is already in use. Please use a different email.")
+		return dbError("error purging user", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError("error purging user", err)
+	}
+	if affected == 0 {
+		return domain.UserNotFound("user with id %s not found", id)
+	}
+
+	return nil
+}
+
+func (u *usersRepository) PurgeDeletedUsersByEmail(ctx context.Context, emails []string, deletedBefore time.Time) ([]domain.User, error) {
+	query := "DELETE FROM users WHERE lower(email) = ANY($1) AND deleted_at IS NOT NULL AND deleted_at <= $2 RETURNING " + usersColumns
+	rows, err := u.conn(ctx).QueryContext(ctx, query, pq.Array(emailKeys(emails)), deletedBefore)
+	if err != nil {
+		return nil, dbError("error purging deleted users", err)
+	}
+	defer rows.Close()
+
+	purged := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, dbError("error occured while scanning rows in 'users'", err)
 		}
-		return nil, fmt.Errorf("error updating user: %v", err)
+		purged = append(purged, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("error purging deleted users", err)
 	}
 
-	return user, nil
+	return purged, nil
 }
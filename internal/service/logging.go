@@ -37,6 +37,25 @@ func (s *LoggingService) GetUsersMany(ctx context.Context, input domain.GetUsers
 	return s.next.GetUsersMany(ctx, input)
 }
 
+func (s *LoggingService) ExportUsers(ctx context.Context, input domain.ExportUsersInput, fn func(domain.User) error) (err error) {
+	start := time.Now()
+	exported := 0
+	defer func() {
+		logger := s.logger.With(slog.Any("exported", exported))
+		if err != nil {
+			logger = logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"ExportUsers",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.ExportUsers(ctx, input, func(user domain.User) error {
+		exported++
+		return fn(user)
+	})
+}
+
 func (s *LoggingService) GetUsersOne(ctx context.Context, input domain.GetUserInput) (response *domain.GetUserResponse, err error) {
 	start := time.Now()
 	defer func() {
@@ -54,6 +73,23 @@ func (s *LoggingService) GetUsersOne(ctx context.Context, input domain.GetUserIn
 	return s.next.GetUsersOne(ctx, input)
 }
 
+func (s *LoggingService) BatchGetUsers(ctx context.Context, input domain.BatchGetUsersInput) (response *domain.BatchGetUsersResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger
+		if response != nil {
+			logger = s.logger.With(slog.Any("found", len(response.Users)), slog.Any("missing", len(response.Missing)))
+		} else {
+			logger = s.logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"BatchGetUsers",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.BatchGetUsers(ctx, input)
+}
+
 func (s *LoggingService) CreateUser(ctx context.Context, input domain.CreateUserInput) (response *domain.GetUserResponse, err error) {
 	start := time.Now()
 	defer func() {
@@ -71,6 +107,46 @@ func (s *LoggingService) CreateUser(ctx context.Context, input domain.CreateUser
 	return s.next.CreateUser(ctx, input)
 }
 
+func (s *LoggingService) CreateUsersBatch(ctx context.Context, input domain.CreateUsersBatchInput) (response *domain.CreateUsersBatchResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger.With(slog.Any("mode", input.Mode), slog.Any("items", len(input.Users)))
+		if response != nil {
+			logger = logger.With(slog.Any("created", response.Created), slog.Any("failed", response.Failed))
+		} else {
+			logger = logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"CreateUsersBatch",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.CreateUsersBatch(ctx, input)
+}
+
+func (s *LoggingService) ImportUsers(ctx context.Context, input domain.ImportUsersInput) (response *domain.ImportUsersResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger.With(slog.Any("dry_run", input.DryRun), slog.Any("rows", len(input.Rows)))
+		if response != nil {
+			logger = logger.With(
+				slog.Any("created", response.Created),
+				slog.Any("updated", response.Updated),
+				slog.Any("unchanged", response.Unchanged),
+				slog.Any("rejected", response.Rejected),
+				slog.Any("failed", response.Failed),
+			)
+		} else {
+			logger = logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"ImportUsers",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.ImportUsers(ctx, input)
+}
+
 func (s *LoggingService) UpdateUser(ctx context.Context, input domain.UpdateUserInput) (response *domain.GetUserResponse, err error) {
 	start := time.Now()
 	defer func() {
@@ -87,3 +163,86 @@ func (s *LoggingService) UpdateUser(ctx context.Context, input domain.UpdateUser
 	}()
 	return s.next.UpdateUser(ctx, input)
 }
+
+func (s *LoggingService) UpsertUser(ctx context.Context, input domain.UpsertUserInput) (response *domain.UpsertUserResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger
+		if response != nil {
+			logger = s.logger.With(slog.Any("user", response.User), slog.Bool("created", response.Created), slog.Bool("changed", response.Changed))
+		} else {
+			logger = s.logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"Upsert",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.UpsertUser(ctx, input)
+}
+
+func (s *LoggingService) PatchUser(ctx context.Context, input domain.PatchUserInput) (response *domain.GetUserResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger
+		if response != nil {
+			logger = s.logger.With(slog.Any("user", response.User))
+		} else {
+			logger = s.logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"Patch",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.PatchUser(ctx, input)
+}
+
+func (s *LoggingService) DeleteUser(ctx context.Context, input domain.DeleteUserInput) (err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger.With(slog.Any("id", input.ID), slog.Any("hard", input.Hard))
+		if err != nil {
+			logger = logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"DeleteUser",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.DeleteUser(ctx, input)
+}
+
+func (s *LoggingService) RestoreUser(ctx context.Context, input domain.RestoreUserInput) (response *domain.GetUserResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger
+		if response != nil {
+			logger = s.logger.With(slog.Any("user", response.User))
+		} else {
+			logger = s.logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"RestoreUser",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.RestoreUser(ctx, input)
+}
+
+func (s *LoggingService) GetUserHistory(ctx context.Context, input domain.GetUserHistoryInput) (response *domain.GetUserHistoryResponse, err error) {
+	start := time.Now()
+	defer func() {
+		logger := s.logger
+		if response != nil {
+			logger = s.logger.With(slog.Any("history total", response.Total))
+		} else {
+			logger = s.logger.With(slog.Any("err", err))
+		}
+		logger.Info(
+			"GetUserHistory",
+			"took", time.Since(start).String(),
+		)
+	}()
+	return s.next.GetUserHistory(ctx, input)
+}
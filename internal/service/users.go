@@ -3,10 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/ExonegeS/REST-API-001/internal/domain"
 	"github.com/ExonegeS/REST-API-001/internal/usecase"
+	"github.com/google/uuid"
 )
 
 type UsersService struct {
@@ -21,7 +24,7 @@ func NewUsersService(usecase usecase.UsersUseCase) domain.Service {
 
 func (u UsersService) GetUsersMany(ctx context.Context, input domain.GetUsersInput) (*domain.GetUsersResponse, error) {
 	if err := input.Validate(); err != nil {
-		return nil, fmt.Errorf("input validation error: %v", err)
+		return nil, domain.Invalid(err)
 	}
 
 	usersList, err := u.UseCase.GetUsersList(ctx, input)
@@ -29,14 +32,30 @@ func (u UsersService) GetUsersMany(ctx context.Context, input domain.GetUsersInp
 		return nil, err
 	}
 	return &domain.GetUsersResponse{
-		Users: usersList.Elements,
-		Total: usersList.Total,
+		Users:      usersList.Elements,
+		Total:      usersList.Total,
+		NextCursor: usersList.NextCursor,
+		PrevCursor: usersList.PrevCursor,
 	}, nil
 }
 
+func (u UsersService) ExportUsers(ctx context.Context, input domain.ExportUsersInput, fn func(domain.User) error) error {
+	if err := input.Validate(); err != nil {
+		return domain.Invalid(err)
+	}
+
+	return u.UseCase.StreamUsers(ctx, domain.GetUsersInput{
+		Query:          input.Query,
+		Sort:           input.Sort,
+		IncludeDeleted: input.IncludeDeleted,
+		Filters:        input.Filters,
+		Fields:         input.Fields,
+	}, fn)
+}
+
 func (u UsersService) GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.GetUserResponse, error) {
 	if err := input.Validate(); err != nil {
-		return nil, fmt.Errorf("input validation error: %v", err)
+		return nil, domain.Invalid(err)
 	}
 
 	user, err := u.UseCase.GetUsersOne(ctx, input)
@@ -48,17 +67,72 @@ func (u UsersService) GetUsersOne(ctx context.Context, input domain.GetUserInput
 	}, nil
 }
 
+func (u UsersService) BatchGetUsers(ctx context.Context, input domain.BatchGetUsersInput) (*domain.BatchGetUsersResponse, error) {
+	// Missing keys are reported as given, before Validate canonicalizes them.
+	emails := slices.Clone(input.Emails)
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
+	}
+
+	ids := make([]uuid.UUID, len(input.IDs))
+	for i, id := range input.IDs {
+		ids[i] = uuid.MustParse(id)
+	}
+
+	users, err := u.UseCase.GetUsersByKeys(ctx, ids, input.Emails, input.IncludeDeleted, input.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]domain.User, len(users))
+	byEmail := make(map[string]domain.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+		byEmail[domain.EmailKey(user.Email)] = user
+	}
+
+	// Users come in the order of the keys, once each.
+	response := &domain.BatchGetUsersResponse{
+		Users:   []domain.User{},
+		Missing: []string{},
+	}
+	added := map[uuid.UUID]bool{}
+	missing := map[string]bool{}
+	add := func(user domain.User, found bool, key string) {
+		switch {
+		case !found && !missing[key]:
+			missing[key] = true
+			response.Missing = append(response.Missing, key)
+		case found && !added[user.ID]:
+			added[user.ID] = true
+			response.Users = append(response.Users, user)
+		}
+	}
+	for i, id := range ids {
+		user, found := byID[id]
+		add(user, found, input.IDs[i])
+	}
+	for i, email := range input.Emails {
+		user, found := byEmail[domain.EmailKey(email)]
+		add(user, found, emails[i])
+	}
+
+	return response, nil
+}
+
 func (u *UsersService) CreateUser(ctx context.Context, input domain.CreateUserInput) (*domain.GetUserResponse, error) {
 	if err := input.Validate(); err != nil {
-		return nil, fmt.Errorf("input validation error: %v", err)
+		return nil, domain.Invalid(err)
 	}
 
 	user, err := u.UseCase.CreateUser(ctx, &domain.User{
-		Email:     input.Email,
-		FirstName: input.FirstName,
-		LastName:  input.LastName,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Email:          input.Email,
+		FirstName:      input.FirstName,
+		LastName:       input.LastName,
+		ExternalSource: input.ExternalSource,
+		ExternalID:     input.ExternalID,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	})
 	if err != nil {
 		return nil, err
@@ -69,39 +143,285 @@ func (u *UsersService) CreateUser(ctx context.Context, input domain.CreateUserIn
 	}, nil
 }
 
+func (u *UsersService) CreateUsersBatch(ctx context.Context, input domain.CreateUsersBatchInput) (*domain.CreateUsersBatchResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
+	}
+
+	response := &domain.CreateUsersBatchResponse{
+		Results: make([]domain.BatchItemResult, len(input.Users)),
+	}
+
+	now := time.Now()
+	users := make([]domain.User, 0, len(input.Users))
+	indexes := make([]int, 0, len(input.Users))
+	seen := map[string]int{}
+	seenExternal := map[[2]string]int{}
+	for i, item := range input.Users {
+		result := &response.Results[i]
+		result.Index = i
+
+		if err := item.Validate(); err != nil {
+			result.Status = domain.BatchItemInvalid
+			result.Error = err.Error()
+			continue
+		}
+		if first, ok := seen[domain.EmailKey(item.Email)]; ok {
+			result.Status = domain.BatchItemConflict
+			result.Error = fmt.Sprintf("email duplicates item %d", first)
+			continue
+		}
+		externalKey := [2]string{item.ExternalSource, item.ExternalID}
+		if first, ok := seenExternal[externalKey]; ok && item.ExternalID != "" {
+			result.Status = domain.BatchItemConflict
+			result.Error = fmt.Sprintf("external id duplicates item %d", first)
+			continue
+		}
+		seen[domain.EmailKey(item.Email)] = i
+		seenExternal[externalKey] = i
+
+		users = append(users, domain.User{
+			Email:          item.Email,
+			FirstName:      item.FirstName,
+			LastName:       item.LastName,
+			ExternalSource: item.ExternalSource,
+			ExternalID:     item.ExternalID,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		indexes = append(indexes, i)
+	}
+
+	atomic := input.Mode == domain.BatchAtomic
+	var created []domain.User
+	var conflicts []string
+	if !atomic || len(users) == len(input.Users) {
+		var err error
+		created, conflicts, err = u.UseCase.CreateUsers(ctx, users, atomic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdByEmail := make(map[string]domain.User, len(created))
+	for _, user := range created {
+		createdByEmail[user.Email] = user
+	}
+	conflicting := make(map[string]struct{}, len(conflicts))
+	for _, email := range conflicts {
+		conflicting[email] = struct{}{}
+	}
+
+	for j, user := range users {
+		result := &response.Results[indexes[j]]
+		if createdUser, ok := createdByEmail[user.Email]; ok {
+			result.Status = domain.BatchItemCreated
+			result.ID = &createdUser.ID
+		} else if _, ok := conflicting[user.Email]; ok {
+			result.Status = domain.BatchItemConflict
+			result.Error = "email or external id already in use"
+		} else {
+			result.Status = domain.BatchItemAborted
+		}
+	}
+
+	response.Created = len(created)
+	response.Failed = len(input.Users) - len(created)
+	return response, nil
+}
+
+func (u *UsersService) ImportUsers(ctx context.Context, input domain.ImportUsersInput) (*domain.ImportUsersResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
+	}
+
+	response := &domain.ImportUsersResponse{
+		Results: make([]domain.ImportRowResult, len(input.Rows)),
+		DryRun:  input.DryRun,
+	}
+
+	now := time.Now()
+	users := make([]domain.User, 0, len(input.Rows))
+	indexes := make([]int, 0, len(input.Rows))
+	seen := map[string]int{}
+	for i, row := range input.Rows {
+		result := &response.Results[i]
+		result.Line = row.Line
+
+		if err := row.User.Validate(); err != nil {
+			result.Status = domain.ImportRejected
+			result.Error = err.Error()
+			continue
+		}
+		if first, ok := seen[domain.EmailKey(row.User.Email)]; ok {
+			result.Status = domain.ImportRejected
+			result.Error = fmt.Sprintf("email duplicates line %d", first)
+			continue
+		}
+		seen[domain.EmailKey(row.User.Email)] = row.Line
+
+		users = append(users, domain.User{
+			Email:     row.User.Email,
+			FirstName: row.User.FirstName,
+			LastName:  row.User.LastName,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		indexes = append(indexes, i)
+	}
+
+	outcomes, err := u.UseCase.ImportUsers(ctx, users, input.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := false
+	for j, outcome := range outcomes {
+		result := &response.Results[indexes[j]]
+		result.Status = outcome.Status
+		if outcome.User != nil && outcome.User.ID != uuid.Nil {
+			result.ID = &outcome.User.ID
+		}
+		switch {
+		case outcome.Status == domain.ImportFailed:
+			// The cause is internal, and only logged.
+			if !failed {
+				slog.Error("import stopped", "err", outcome.Err, "line", result.Line)
+				failed = true
+			}
+			result.Error = "not imported: the import stopped on an error before this row was committed"
+		case outcome.Err != nil:
+			result.Error = outcome.Err.Error()
+		}
+	}
+
+	for _, result := range response.Results {
+		switch result.Status {
+		case domain.ImportCreated:
+			response.Created++
+		case domain.ImportUpdated:
+			response.Updated++
+		case domain.ImportUnchanged:
+			response.Unchanged++
+		case domain.ImportRejected:
+			response.Rejected++
+		case domain.ImportFailed:
+			response.Failed++
+		}
+	}
+	return response, nil
+}
+
 func (u *UsersService) UpdateUser(ctx context.Context, input domain.UpdateUserInput) (*domain.GetUserResponse, error) {
 	if err := input.Validate(); err != nil {
-		return nil, fmt.Errorf("input validation error: %v", err)
+		return nil, domain.Invalid(err)
 	}
 
-	user, err := u.UseCase.GetUsersOne(ctx, domain.GetUserInput{
-		ID: &input.ID,
+	user, err := u.UseCase.UpdateUser(ctx, uuid.MustParse(input.ID), func(user *domain.User) error {
+		if !expectsVersion(input.ExpectedVersions, user.Version) {
+			return domain.ErrVersionConflict
+		}
+
+		setUserFields(user, input)
+		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	return &domain.GetUserResponse{
+		User: *user,
+	}, nil
+}
+
+func (u *UsersService) UpsertUser(ctx context.Context, input domain.UpsertUserInput) (*domain.UpsertUserResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
+	}
+
 	now := time.Now()
-	if input.Email != nil {
-		if user.Email != *input.Email {
-			user.UpdatedAt = now
-		}
-		user.Email = *input.Email
+	user, created, changed, err := u.UseCase.UpsertUser(ctx, &domain.User{
+		Email:          input.Email,
+		FirstName:      input.FirstName,
+		LastName:       input.LastName,
+		ExternalSource: input.ExternalSource,
+		ExternalID:     input.ExternalID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		return nil, err
 	}
-	if input.FirstName != nil {
-		if user.FirstName != *input.FirstName {
-			user.UpdatedAt = now
-		}
-		user.FirstName = *input.FirstName
+
+	return &domain.UpsertUserResponse{
+		User:    *user,
+		Created: created,
+		Changed: changed,
+	}, nil
+}
+
+func (u *UsersService) PatchUser(ctx context.Context, input domain.PatchUserInput) (*domain.GetUserResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
 	}
-	if input.LastName != nil {
-		if user.LastName != *input.LastName {
-			user.UpdatedAt = now
+
+	user, err := u.UseCase.UpdateUser(ctx, uuid.MustParse(input.ID), func(user *domain.User) error {
+		if !expectsVersion(input.ExpectedVersions, user.Version) {
+			return domain.ErrVersionConflict
 		}
-		user.LastName = *input.LastName
+
+		update, err := input.Patch.Apply(*user)
+		if err != nil {
+			return err
+		}
+		if err := update.Validate(); err != nil {
+			return domain.Invalid(err)
+		}
+
+		setUserFields(user, *update)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.GetUserResponse{
+		User: *user,
+	}, nil
+}
+
+// setUserFields stores the fields of update in user, moving UpdatedAt if
+// any of them changes.
+func setUserFields(user *domain.User, update domain.UpdateUserInput) {
+	if user.Email != update.Email || user.FirstName != update.FirstName || user.LastName != update.LastName ||
+		user.ExternalSource != update.ExternalSource || user.ExternalID != update.ExternalID {
+		user.UpdatedAt = time.Now()
+	}
+	user.Email = update.Email
+	user.FirstName = update.FirstName
+	user.LastName = update.LastName
+	user.ExternalSource = update.ExternalSource
+	user.ExternalID = update.ExternalID
+}
+
+func (u *UsersService) DeleteUser(ctx context.Context, input domain.DeleteUserInput) error {
+	if err := input.Validate(); err != nil {
+		return domain.Invalid(err)
+	}
+
+	id := uuid.MustParse(input.ID)
+	if input.Hard {
+		return u.UseCase.PurgeUser(ctx, id)
+	}
+	return u.UseCase.DeleteUser(ctx, id)
+}
+
+func (u *UsersService) RestoreUser(ctx context.Context, input domain.RestoreUserInput) (*domain.GetUserResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
 	}
 
-	user, err = u.UseCase.UpdateUser(ctx, user)
+	user, err := u.UseCase.RestoreUser(ctx, uuid.MustParse(input.ID))
 	if err != nil {
 		return nil, err
 	}
@@ -110,3 +430,25 @@ func (u *UsersService) UpdateUser(ctx context.Context, input domain.UpdateUserIn
 		User: *user,
 	}, nil
 }
+
+func (u *UsersService) GetUserHistory(ctx context.Context, input domain.GetUserHistoryInput) (*domain.GetUserHistoryResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, domain.Invalid(err)
+	}
+
+	history, err := u.UseCase.GetUserHistory(ctx, uuid.MustParse(input.ID), input.Limit, input.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.GetUserHistoryResponse{
+		History: history.Elements,
+		Total:   history.Total,
+	}, nil
+}
+
+// expectsVersion reports whether version is among expected, which expects
+// any version when nil.
+func expectsVersion(expected []int64, version int64) bool {
+	return expected == nil || slices.Contains(expected, version)
+}
@@ -2,25 +2,87 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/ExonegeS/REST-API-001/internal/domain"
 	"github.com/ExonegeS/REST-API-001/internal/repository"
+	"github.com/google/uuid"
 )
 
 type UsersUseCase interface {
 	GetUsersList(ctx context.Context, input domain.GetUsersInput) (*domain.List[domain.User], error)
+	StreamUsers(ctx context.Context, input domain.GetUsersInput, fn func(user domain.User) error) error
 	GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.User, error)
+	GetUsersByKeys(ctx context.Context, ids []uuid.UUID, emails []string, includeDeleted bool, fields []string) ([]domain.User, error)
 	CreateUser(ctx context.Context, user *domain.User) (*domain.User, error)
-	UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error)
+	// CreateUsers inserts users in bulk and returns the ones created and the
+	// emails of those whose email or external id was already in use. With
+	// atomic set, nothing is created unless every user can be.
+	CreateUsers(ctx context.Context, users []domain.User, atomic bool) (created []domain.User, conflicts []string, err error)
+	// ImportUsers creates users, or updates the names of the users they match
+	// by email, in batches of one transaction each. A dry run reports the
+	// outcomes without writing anything. The error of the first batch is
+	// returned, since nothing is written then; that of a later batch fails
+	// the users from it on with ImportFailed outcomes instead, so that the
+	// committed batches are still reported.
+	ImportUsers(ctx context.Context, users []domain.User, dryRun bool) ([]ImportOutcome, error)
+	// UpdateUser locks the active user with id, applies update to it and
+	// stores the result, all in one transaction. Nothing is stored when the
+	// update changes no field.
+	UpdateUser(ctx context.Context, id uuid.UUID, update func(user *domain.User) error) (*domain.User, error)
+	// UpsertUser creates user, or updates the active user holding its email
+	// when any of its writable fields differ, in one transaction. It reports
+	// whether the user was created and whether anything changed.
+	UpsertUser(ctx context.Context, user *domain.User) (stored *domain.User, created, changed bool, err error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	RestoreUser(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	PurgeUser(ctx context.Context, id uuid.UUID) error
+	GetUserHistory(ctx context.Context, id uuid.UUID, limit, offset int) (*domain.List[domain.AuditRecord], error)
+}
+
+// errBatchConflict rolls back an atomic batch in which some users conflict.
+var errBatchConflict = errors.New("batch contains conflicting users")
+
+// errUpsertRaced rolls back an upsert that updated a user created after it
+// looked the email up, so that it can be retried with the user locked.
+var errUpsertRaced = errors.New("user created concurrently")
+
+// upsertAttempts bounds the retries of upserts that raced.
+const upsertAttempts = 3
+
+// ImportOutcome is what an import did, or would do, with one user.
+type ImportOutcome struct {
+	Status domain.ImportRowStatus
+	// User is the user as stored, or as it would be stored in a dry run.
+	User *domain.User
+	// Err explains why a user is rejected.
+	Err error
+}
+
+// importBatchSize is the number of users imported per transaction.
+const importBatchSize = 500
+
+// UsersPolicy holds the business rules that are configurable per deployment.
+type UsersPolicy struct {
+	// EmailReuseAfter is how long a soft-deleted user keeps its email
+	// reserved. Zero means the email is only released by a purge.
+	EmailReuseAfter time.Duration
 }
 
 type usersUseCase struct {
 	usersRepository repository.UsersRepository
+	auditRepository repository.AuditRepository
+	txManager       repository.TxManager
+	policy          UsersPolicy
 }
 
-func NewUsersUseCase(repository repository.UsersRepository) *usersUseCase {
+func NewUsersUseCase(repository repository.UsersRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, policy UsersPolicy) *usersUseCase {
 	return &usersUseCase{
 		usersRepository: repository,
+		auditRepository: auditRepository,
+		txManager:       txManager,
+		policy:          policy,
 	}
 }
 
@@ -28,14 +90,404 @@ func (u *usersUseCase) GetUsersList(ctx context.Context, input domain.GetUsersIn
 	return u.usersRepository.GetUsersList(ctx, input)
 }
 
+func (u *usersUseCase) StreamUsers(ctx context.Context, input domain.GetUsersInput, fn func(user domain.User) error) error {
+	return u.usersRepository.StreamUsers(ctx, input, fn)
+}
+
 func (u *usersUseCase) GetUsersOne(ctx context.Context, input domain.GetUserInput) (*domain.User, error) {
 	return u.usersRepository.GetUsersOne(ctx, input)
 }
 
+func (u *usersUseCase) GetUsersByKeys(ctx context.Context, ids []uuid.UUID, emails []string, includeDeleted bool, fields []string) ([]domain.User, error) {
+	return u.usersRepository.GetUsersByKeys(ctx, ids, emails, includeDeleted, fields)
+}
+
 func (u *usersUseCase) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	return u.usersRepository.InsertUser(ctx, user)
+	var created *domain.User
+	err := u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.releaseEmail(ctx, user.Email); err != nil {
+			return err
+		}
+
+		var err error
+		created, err = u.usersRepository.InsertUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return u.audit(ctx, domain.AuditCreate, nil, created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (u *usersUseCase) CreateUsers(ctx context.Context, users []domain.User, atomic bool) ([]domain.User, []string, error) {
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+
+	var created []domain.User
+	var conflicts []string
+	err := u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.releaseEmail(ctx, emails...); err != nil {
+			return err
+		}
+
+		var err error
+		created, err = u.usersRepository.InsertUsers(ctx, users)
+		if err != nil {
+			return err
+		}
+
+		createdEmails := make(map[string]struct{}, len(created))
+		for _, user := range created {
+			createdEmails[user.Email] = struct{}{}
+		}
+		for _, email := range emails {
+			if _, ok := createdEmails[email]; !ok {
+				conflicts = append(conflicts, email)
+			}
+		}
+		if atomic && len(conflicts) > 0 {
+			return errBatchConflict
+		}
+
+		records := make([]domain.AuditRecord, 0, len(created))
+		for i := range created {
+			records = append(records, u.auditRecord(ctx, domain.AuditCreate, nil, &created[i]))
+		}
+		return u.auditRepository.InsertAuditRecords(ctx, records)
+	})
+	if errors.Is(err, errBatchConflict) {
+		return nil, conflicts, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return created, conflicts, nil
+}
+
+func (u *usersUseCase) ImportUsers(ctx context.Context, users []domain.User, dryRun bool) ([]ImportOutcome, error) {
+	outcomes := make([]ImportOutcome, 0, len(users))
+	for start := 0; start < len(users); start += importBatchSize {
+		batch := users[start:min(start+importBatchSize, len(users))]
+
+		var batchOutcomes []ImportOutcome
+		importBatch := func(ctx context.Context) error {
+			var err error
+			batchOutcomes, err = u.importBatch(ctx, batch, dryRun)
+			return err
+		}
+
+		var err error
+		if dryRun {
+			err = importBatch(ctx)
+		} else {
+			err = u.txManager.WithinTransaction(ctx, importBatch)
+		}
+		if err != nil && start == 0 {
+			return nil, err
+		}
+		if err != nil {
+			for range users[start:] {
+				outcomes = append(outcomes, ImportOutcome{Status: domain.ImportFailed, Err: err})
+			}
+			return outcomes, nil
+		}
+		outcomes = append(outcomes, batchOutcomes...)
+	}
+	return outcomes, nil
+}
+
+func (u *usersUseCase) importBatch(ctx context.Context, users []domain.User, dryRun bool) ([]ImportOutcome, error) {
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+
+	if !dryRun {
+		if err := u.releaseEmail(ctx, emails...); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := u.usersRepository.GetUsersByEmails(ctx, emails, !dryRun)
+	if err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string]domain.User, len(existing))
+	for _, user := range existing {
+		byEmail[domain.EmailKey(user.Email)] = user
+	}
+
+	outcomes := make([]ImportOutcome, len(users))
+	var inserts []domain.User
+	var records []domain.AuditRecord
+	for i, user := range users {
+		current, ok := byEmail[domain.EmailKey(user.Email)]
+		switch {
+		case !ok || (dryRun && u.emailReleasable(current)):
+			outcomes[i] = ImportOutcome{Status: domain.ImportCreated, User: &users[i]}
+			inserts = append(inserts, user)
+		case current.DeletedAt != nil:
+			outcomes[i] = ImportOutcome{Status: domain.ImportRejected, Err: domain.EmailInUse("email already in use by a deleted user")}
+		case current.FirstName == user.FirstName && current.LastName == user.LastName:
+			outcomes[i] = ImportOutcome{Status: domain.ImportUnchanged, User: &current}
+		default:
+			updated := current
+			updated.FirstName = user.FirstName
+			updated.LastName = user.LastName
+			updated.UpdatedAt = user.UpdatedAt
+			if !dryRun {
+				if _, err := u.usersRepository.UpdateUser(ctx, &updated); err != nil {
+					return nil, err
+				}
+				records = append(records, u.auditRecord(ctx, domain.AuditUpdate, &current, &updated))
+			}
+			outcomes[i] = ImportOutcome{Status: domain.ImportUpdated, User: &updated}
+		}
+	}
+
+	if dryRun {
+		return outcomes, nil
+	}
+
+	if len(inserts) > 0 {
+		created, err := u.usersRepository.InsertUsers(ctx, inserts)
+		if err != nil {
+			return nil, err
+		}
+		createdByEmail := make(map[string]domain.User, len(created))
+		for _, user := range created {
+			createdByEmail[user.Email] = user
+		}
+
+		for i := range outcomes {
+			if outcomes[i].Status != domain.ImportCreated {
+				continue
+			}
+			user, ok := createdByEmail[outcomes[i].User.Email]
+			if !ok {
+				// Taken by a concurrent insert since the lookup.
+				outcomes[i] = ImportOutcome{Status: domain.ImportRejected, Err: domain.EmailInUse("email already in use")}
+				continue
+			}
+			outcomes[i].User = &user
+			records = append(records, u.auditRecord(ctx, domain.AuditCreate, nil, &user))
+		}
+	}
+
+	return outcomes, u.auditRepository.InsertAuditRecords(ctx, records)
+}
+
+// emailReleasable reports whether user is soft-deleted long enough ago for
+// releaseEmail to purge it.
+func (u *usersUseCase) emailReleasable(user domain.User) bool {
+	if user.DeletedAt == nil || u.policy.EmailReuseAfter <= 0 {
+		return false
+	}
+	return !user.DeletedAt.After(time.Now().Add(-u.policy.EmailReuseAfter))
+}
+
+func (u *usersUseCase) UpdateUser(ctx context.Context, id uuid.UUID, update func(user *domain.User) error) (*domain.User, error) {
+	var updated *domain.User
+	err := u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := u.usersRepository.GetUserForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		before := *user
+
+		if err := update(user); err != nil {
+			return err
+		}
+
+		// An update that changes nothing leaves the user, its version and
+		// its history alone, so that its validators stay current.
+		if len(domain.DiffUsers(&before, user)) == 0 {
+			updated = &before
+			return nil
+		}
+
+		if domain.EmailKey(user.Email) != domain.EmailKey(before.Email) {
+			if err := u.releaseEmail(ctx, user.Email); err != nil {
+				return err
+			}
+		}
+
+		updated, err = u.usersRepository.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return u.audit(ctx, domain.AuditUpdate, &before, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (u *usersUseCase) UpsertUser(ctx context.Context, user *domain.User) (*domain.User, bool, bool, error) {
+	for attempt := 1; ; attempt++ {
+		var stored *domain.User
+		var created, changed bool
+		err := u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+			if err := u.releaseEmail(ctx, user.Email); err != nil {
+				return err
+			}
+
+			// The current user is locked first, so that the update can be
+			// audited against it.
+			existing, err := u.usersRepository.GetUsersByEmails(ctx, []string{user.Email}, true)
+			if err != nil {
+				return err
+			}
+			var before *domain.User
+			if len(existing) > 0 {
+				before = &existing[0]
+				if before.DeletedAt != nil {
+					return domain.EmailInUse("email already in use by a deleted user")
+				}
+			}
+
+			upserted, inserted, err := u.usersRepository.UpsertUser(ctx, user)
+			if err != nil {
+				return err
+			}
+			switch {
+			case inserted:
+				stored, created, changed = upserted, true, true
+				return u.audit(ctx, domain.AuditCreate, nil, stored)
+			case before == nil:
+				return errUpsertRaced
+			case upserted == nil:
+				stored = before
+				return nil
+			default:
+				stored, changed = upserted, true
+				return u.audit(ctx, domain.AuditUpdate, before, stored)
+			}
+		})
+		if errors.Is(err, errUpsertRaced) && attempt < upsertAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, false, false, err
+		}
+		return stored, created, changed, nil
+	}
+}
+
+func (u *usersUseCase) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := u.usersRepository.GetUserForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		deletedAt := time.Now()
+		if err := u.usersRepository.SoftDeleteUser(ctx, id, deletedAt); err != nil {
+			return err
+		}
+
+		deleted := *user
+		deleted.DeletedAt = &deletedAt
+		return u.audit(ctx, domain.AuditDelete, user, &deleted)
+	})
+}
+
+func (u *usersUseCase) RestoreUser(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	var restored *domain.User
+	err := u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		idStr := id.String()
+		user, err := u.usersRepository.GetUsersOne(ctx, domain.GetUserInput{
+			ID:             &idStr,
+			IncludeDeleted: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		restored, err = u.usersRepository.RestoreUser(ctx, id, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return u.audit(ctx, domain.AuditRestore, user, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func (u *usersUseCase) PurgeUser(ctx context.Context, id uuid.UUID) error {
+	return u.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		idStr := id.String()
+		user, err := u.usersRepository.GetUsersOne(ctx, domain.GetUserInput{
+			ID:             &idStr,
+			IncludeDeleted: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := u.usersRepository.PurgeUser(ctx, id); err != nil {
+			return err
+		}
+
+		return u.audit(ctx, domain.AuditPurge, user, nil)
+	})
+}
+
+func (u *usersUseCase) GetUserHistory(ctx context.Context, id uuid.UUID, limit, offset int) (*domain.List[domain.AuditRecord], error) {
+	return u.auditRepository.GetAuditRecords(ctx, id, limit, offset)
+}
+
+// releaseEmail purges the soft-deleted users holding emails once the policy
+// allows the addresses to be reused.
+func (u *usersUseCase) releaseEmail(ctx context.Context, emails ...string) error {
+	if u.policy.EmailReuseAfter <= 0 {
+		return nil
+	}
+
+	purged, err := u.usersRepository.PurgeDeletedUsersByEmail(ctx, emails, time.Now().Add(-u.policy.EmailReuseAfter))
+	if err != nil {
+		return err
+	}
+	for _, user := range purged {
+		if err := u.audit(ctx, domain.AuditPurge, &user, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (u *usersUseCase) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	return u.usersRepository.UpdateUser(ctx, user)
+// audit records the change of a user from before to after. Updates that
+// change no audited field are not recorded.
+func (u *usersUseCase) audit(ctx context.Context, operation domain.AuditOperation, before, after *domain.User) error {
+	record := u.auditRecord(ctx, operation, before, after)
+	if operation == domain.AuditUpdate && len(record.Changes) == 0 {
+		return nil
+	}
+	return u.auditRepository.InsertAuditRecord(ctx, &record)
+}
+
+func (u *usersUseCase) auditRecord(ctx context.Context, operation domain.AuditOperation, before, after *domain.User) domain.AuditRecord {
+	subject := before
+	if subject == nil {
+		subject = after
+	}
+
+	return domain.AuditRecord{
+		UserID:    subject.ID,
+		Actor:     domain.ActorFromContext(ctx),
+		Operation: operation,
+		Changes:   domain.DiffUsers(before, after),
+		CreatedAt: time.Now(),
+	}
 }